@@ -0,0 +1,320 @@
+package Admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+var (
+	errDispatchDelivered = errors.New("dispatch already delivered")
+	errAlreadyAssigned   = errors.New("dispatch already assigned to this driver and vehicle")
+	errNotReschedulable  = errors.New("only failed or partly delivered dispatches can be rescheduled")
+)
+
+// ReassignDispatch hands a dispatch to another driver and/or vehicle. The
+// current trip is cancelled, a new one is created for the new holder and
+// the change is added to the dispatch's assignment history. Body:
+//
+//	{"driver": {"idNumber": 123}, "vehicle": {"reg_no": "KAA 123A"}, "reason": "..."}
+//
+// Either driver or vehicle may be omitted to keep the current one.
+func ReassignDispatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Driver  store.Driver  `json:"driver"`
+		Vehicle store.Vehicle `json:"vehicle"`
+		Reason  string        `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if body.Driver.IDNumber == 0 && body.Vehicle.RegNo == "" {
+		http.Error(w, "A driver or vehicle is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	actor := auth.Actor(ctx)
+	var reason *string
+	if s := strings.TrimSpace(body.Reason); s != "" {
+		reason = &s
+	}
+
+	var d *Dispatch
+	var previous, trip *Trips
+	fromDriverID := 0
+	assignment := store.Assignment{DispatchID: id, AssignedBy: actor, Reason: reason}
+	err = repo.InTx(ctx, func(tx store.Repo) error {
+		// Serialises reassigns, reschedules and outcomes for the dispatch so
+		// only one of them can close the open trip
+		if err := tx.Dispatches().Lock(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		d, err = tx.Dispatches().Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if d.Verified || d.Status == store.DispatchDelivered {
+			return errDispatchDelivered
+		}
+		fromDriverID = d.Driver.ID
+
+		driverID, vehicleID := d.Driver.ID, d.Vehicle.ID
+		if body.Driver.IDNumber != 0 {
+			if driverID, err = tx.Drivers().IDByIDNumber(ctx, body.Driver.IDNumber); err != nil {
+				return lookupErr(err, errDriverNotFound)
+			}
+		}
+		if body.Vehicle.RegNo != "" {
+			if vehicleID, err = tx.Vehicles().IDByRegNo(ctx, body.Vehicle.RegNo); err != nil {
+				return lookupErr(err, errVehicleNotFound)
+			}
+		}
+
+		previous, err = tx.Trips().ActiveForDispatch(ctx, id)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			previous = nil
+		case err != nil:
+			return err
+		case previous.Driver.ID == driverID && previous.Vehicle.ID == vehicleID:
+			return errAlreadyAssigned
+		default:
+			note := "reassigned"
+			if reason != nil {
+				note += ": " + *reason
+			}
+			if previous, err = tx.Trips().Transition(ctx, previous.ID, store.TripCancelled, actor, note); err != nil {
+				return err
+			}
+		}
+
+		if trip, err = assignTrip(ctx, tx, d, driverID, vehicleID, &assignment); err != nil {
+			return err
+		}
+
+		d, err = tx.Dispatches().Get(ctx, id)
+		return err
+	})
+	var te *store.TransitionError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Dispatch not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errDriverNotFound):
+		http.Error(w, "Driver not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errVehicleNotFound):
+		http.Error(w, "Vehicle not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errDispatchDelivered), errors.Is(err, errAlreadyAssigned), errors.As(err, &te):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reassign dispatch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Both drivers hear about it through the event; see Driver.forwardReassignment
+	payload := map[string]interface{}{
+		"dispatchId":   id,
+		"trip":         trip,
+		"assignment":   assignment,
+		"fromDriverId": fromDriverID,
+		"toDriverId":   trip.Driver.ID,
+	}
+	if previous != nil {
+		payload["previousTrip"] = previous
+	}
+	events.Publish("dispatch_reassigned", payload)
+	events.Publish("trip_created", map[string]interface{}{
+		"trip": trip,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dispatch":     d,
+		"trip":         trip,
+		"previousTrip": previous,
+		"assignment":   assignment,
+	})
+}
+
+// RescheduleDispatch puts a failed or partly delivered dispatch back on the
+// road with a new trip. Body, all optional:
+//
+//	{"driver": {"idNumber": 123}, "vehicle": {"reg_no": "KAA 123A"}, "reason": "..."}
+//
+// The current driver and vehicle are kept unless others are given.
+func RescheduleDispatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Driver  store.Driver  `json:"driver"`
+		Vehicle store.Vehicle `json:"vehicle"`
+		Reason  string        `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	note := "rescheduled"
+	if s := strings.TrimSpace(body.Reason); s != "" {
+		note += ": " + s
+	}
+
+	var d *Dispatch
+	var trip *Trips
+	var previousStatus string
+	fromDriverID := 0
+	assignment := store.Assignment{AssignedBy: auth.Actor(ctx), Reason: &note}
+	err = repo.InTx(ctx, func(tx store.Repo) error {
+		if err := tx.Dispatches().Lock(ctx, id); err != nil {
+			return err
+		}
+		var err error
+		d, err = tx.Dispatches().Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !store.NeedsReschedule(d.Status) {
+			return errNotReschedulable
+		}
+		previousStatus = d.Status
+		fromDriverID = d.Driver.ID
+
+		driverID, vehicleID := d.Driver.ID, d.Vehicle.ID
+		if body.Driver.IDNumber != 0 {
+			if driverID, err = tx.Drivers().IDByIDNumber(ctx, body.Driver.IDNumber); err != nil {
+				return lookupErr(err, errDriverNotFound)
+			}
+		}
+		if body.Vehicle.RegNo != "" {
+			if vehicleID, err = tx.Vehicles().IDByRegNo(ctx, body.Vehicle.RegNo); err != nil {
+				return lookupErr(err, errVehicleNotFound)
+			}
+		}
+
+		if trip, err = assignTrip(ctx, tx, d, driverID, vehicleID, &assignment); err != nil {
+			return err
+		}
+
+		d, err = tx.Dispatches().Get(ctx, id)
+		return err
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Dispatch not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errDriverNotFound):
+		http.Error(w, "Driver not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errVehicleNotFound):
+		http.Error(w, "Vehicle not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errNotReschedulable):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reschedule dispatch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The driver hears about the new trip through the event; see Driver.forwardReassignment
+	events.Publish("dispatch_rescheduled", map[string]interface{}{
+		"dispatchId":     id,
+		"trip":           trip,
+		"assignment":     assignment,
+		"fromDriverId":   fromDriverID,
+		"toDriverId":     trip.Driver.ID,
+		"previousStatus": previousStatus,
+	})
+	events.Publish("trip_created", map[string]interface{}{
+		"trip": trip,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dispatch":   d,
+		"trip":       trip,
+		"assignment": assignment,
+	})
+}
+
+// assignTrip gives dispatch d to driverID and vehicleID: it creates their trip
+// and opens the assignment a, which must carry AssignedBy and Reason. A failed
+// or partly delivered dispatch goes back to pending.
+func assignTrip(ctx context.Context, tx store.Repo, d *Dispatch, driverID, vehicleID int, a *store.Assignment) (*Trips, error) {
+	if err := tx.Dispatches().Reassign(ctx, d.ID, driverID, vehicleID); err != nil {
+		return nil, err
+	}
+	if store.NeedsReschedule(d.Status) {
+		if err := tx.Dispatches().SetStatus(ctx, d.ID, store.DispatchPending); err != nil {
+			return nil, err
+		}
+	}
+	trip, err := AutoCreateTrip(ctx, tx, d.ID, driverID, vehicleID, d.Location, d.Recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	a.DispatchID = d.ID
+	a.Driver.ID = driverID
+	a.Vehicle.ID = vehicleID
+	a.TripID = trip.ID
+	if err := tx.Assignments().Open(ctx, a); err != nil {
+		return nil, err
+	}
+	return trip, nil
+}
+
+// GetDispatchAssignments lists who held a dispatch and when, oldest first
+func GetDispatchAssignments(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := repo.Dispatches().Get(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Dispatch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	history, err := repo.Assignments().ListByDispatch(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -3,12 +3,15 @@ package Admin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -38,9 +41,9 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbPool == nil {
+	if repo == nil {
 		http.Error(w, `{"success":false,"message":"Database not initialized"}`, http.StatusInternalServerError)
-		log.Println("[Signup] DB pool is nil")
+		log.Println("[Signup] Store is nil")
 		return
 	}
 
@@ -59,19 +62,23 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-		INSERT INTO public.admin_users (first_name, last_name, email, password)
-		VALUES ($1, $2, $3, $4)
-	`
+	// Self-registration only bootstraps the very first account, which becomes the owner.
+	// After that, admins are added by an owner.
+	owner := store.AdminUser{FirstName: reg.FirstName, LastName: reg.LastName, Email: reg.Email}
 
 	// Use request-scoped context with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	_, err = dbPool.Exec(ctx, query, reg.FirstName, reg.LastName, reg.Email, string(hashedPassword))
+	created, err := repo.AdminUsers().CreateFirstOwner(ctx, &owner, string(hashedPassword))
+	if err == nil && !created {
+		http.Error(w, `{"success":false,"message":"Self-registration is disabled, ask an owner for an invitation"}`, http.StatusForbidden)
+		log.Println("[Signup] Rejected self-registration:", reg.Email)
+		return
+	}
 	if err != nil {
 		// Handle duplicate email separately
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+		if errors.Is(err, store.ErrDuplicate) {
 			http.Error(w, `{"success":false,"message":"Email already registered"}`, http.StatusConflict)
 			log.Println("[Signup] Duplicate email:", reg.Email)
 			return
@@ -91,10 +98,11 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 			"firstName": reg.FirstName,
 			"lastName":  reg.LastName,
 			"email":     reg.Email,
+			"role":      auth.AdminOwner,
 		},
 	})
 
-	log.Println("[Signup] User registered:", reg.Email)
+	log.Println("[Signup] Owner registered:", reg.Email)
 }
 
 // --- LoginHandler ---
@@ -104,9 +112,9 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbPool == nil {
+	if repo == nil {
 		http.Error(w, `{"success":false,"message":"Database not initialized"}`, http.StatusInternalServerError)
-		log.Println("[Login] DB pool is nil")
+		log.Println("[Login] Store is nil")
 		return
 	}
 
@@ -117,44 +125,207 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var adminID int
+	var adminRole string
 	var storedHashedPassword string
 
 	// Add context with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	err := dbPool.QueryRow(ctx,
-		"SELECT password FROM public.admin_users WHERE email=$1",
-		creds.Email).Scan(&storedHashedPassword)
+	accountKey := auth.AccountKey(auth.RoleAdmin, creds.Email)
+	ipKey := auth.IPKey(r)
 
+	wait, err := auth.CheckLockout(ctx, accountKey, ipKey)
 	if err != nil {
-		http.Error(w, `{"success":false,"message":"Invalid email or password"}`, http.StatusUnauthorized)
-		log.Println("[Login] Query error:", err)
+		http.Error(w, `{"success":false,"message":"Failed to check login attempts"}`, http.StatusInternalServerError)
+		log.Println("[Login] Lockout check error:", err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, `{"success":false,"message":"Too many failed attempts, try again later"}`, http.StatusTooManyRequests)
 		return
 	}
 
+	adminID, adminRole, storedHashedPassword, err = repo.AdminUsers().Credentials(ctx, creds.Email)
+	if err != nil {
+		log.Println("[Login] Query error:", err)
+		auth.CompareDummyPassword(creds.Password)
+	}
+
 	// ✅ Compare hashed password
-	if bcrypt.CompareHashAndPassword([]byte(storedHashedPassword), []byte(creds.Password)) != nil {
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(storedHashedPassword), []byte(creds.Password)) != nil {
+		if err := auth.RecordLoginFailure(ctx, accountKey, ipKey); err != nil {
+			log.Println("[Login] Failed to record attempt:", err)
+		}
 		http.Error(w, `{"success":false,"message":"Invalid email or password"}`, http.StatusUnauthorized)
 		return
 	}
 
+	if err := auth.RecordLoginSuccess(ctx, accountKey); err != nil {
+		log.Println("[Login] Failed to clear attempts:", err)
+	}
+
+	// ✅ Start a session (access + refresh token)
+	tokens, err := auth.CreateSession(ctx, auth.RoleAdmin, adminID)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Could not create session"}`, http.StatusInternalServerError)
+		log.Println("[Login] Token error:", err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(APIResponse{
 		Success: true,
 		Message: "User logged in!",
-		Data: map[string]string{
-			"email": creds.Email,
+		Data: map[string]interface{}{
+			"id":               adminID,
+			"email":            creds.Email,
+			"role":             adminRole,
+			"accessToken":      tokens.AccessToken,
+			"refreshToken":     tokens.RefreshToken,
+			"tokenType":        tokens.TokenType,
+			"expiresAt":        tokens.ExpiresAt,
+			"refreshExpiresAt": tokens.RefreshExpiresAt,
 		},
 	})
 
 	log.Println("[Login] User logged in:", creds.Email)
 }
 
+// --- RefreshHandler ---
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, `{"success":false,"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tokens, err := auth.RefreshSession(ctx, auth.RoleAdmin, body.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		http.Error(w, `{"success":false,"message":"Invalid or expired refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Could not refresh session"}`, http.StatusInternalServerError)
+		log.Println("[Refresh] Session error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "Session refreshed",
+		Data:    tokens,
+	})
+}
+
+// --- LogoutHandler ---
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, `{"success":false,"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := auth.RevokeSession(ctx, claims.SessionID); err != nil {
+		http.Error(w, `{"success":false,"message":"Logout failed"}`, http.StatusInternalServerError)
+		log.Println("[Logout] Revoke error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Message: "Logged out"})
+}
+
+// --- RevokeDriverSessionsHandler ---
+// Kills every session for a drivers.id, e.g. when a phone is lost or a driver leaves.
+func RevokeDriverSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	driverID, err := strconv.Atoi(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Invalid driver ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	revoked, err := auth.RevokeAllSessions(ctx, auth.RoleDriver, driverID)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Failed to revoke sessions"}`, http.StatusInternalServerError)
+		log.Println("[Sessions] Revoke error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "Driver sessions revoked",
+		Data: map[string]interface{}{
+			"driverId": driverID,
+			"revoked":  revoked,
+		},
+	})
+
+	log.Printf("[Sessions] Revoked %d sessions for driver %d\n", revoked, driverID)
+}
+
+// --- ListLockoutsHandler ---
+// Shows accounts and IPs with recent failed logins or an active lockout
+func ListLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := auth.ListLockouts(r.Context())
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Failed to fetch lockouts"}`, http.StatusInternalServerError)
+		log.Println("[Lockouts] List error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Message: "Lockouts", Data: list})
+}
+
+// --- ClearLockoutHandler ---
+// Clears a lockout by key, e.g. "driver:12345678", "admin:jane@example.com" or "ip:1.2.3.4"
+func ClearLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	if err := auth.ClearLockout(r.Context(), key); err != nil {
+		http.Error(w, `{"success":false,"message":"Failed to clear lockout"}`, http.StatusInternalServerError)
+		log.Println("[Lockouts] Clear error:", err)
+		return
+	}
+
+	log.Println("[Lockouts] Cleared:", key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // --- Register routes ---
 func RegisterAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/register", SignupHandler).Methods("POST")
 	r.HandleFunc("/login", LoginHandler).Methods("POST")
+	r.HandleFunc("/refresh", RefreshHandler).Methods("POST")
+
+	// Invitations and password reset
+	r.HandleFunc("/invitations/accept", AcceptInviteHandler).Methods("POST")
+	r.HandleFunc("/password/forgot", ForgotPasswordHandler).Methods("POST")
+	r.HandleFunc("/password/reset", ResetPasswordHandler).Methods("POST")
+}
+
+// RegisterSessionRoutes registers session endpoints that need a valid admin token
+func RegisterSessionRoutes(r *mux.Router) {
+	r.HandleFunc("/logout", LogoutHandler).Methods("POST")
+	r.HandleFunc("/drivers/{driverId}/sessions", auth.RequirePermission(RevokeDriverSessionsHandler, auth.PermManageDrivers)).Methods("DELETE")
+	r.HandleFunc("/lockouts", auth.RequirePermission(ListLockoutsHandler, auth.PermManageLockouts)).Methods("GET")
+	r.HandleFunc("/lockouts/{key}", auth.RequirePermission(ClearLockoutHandler, auth.PermManageLockouts)).Methods("DELETE")
 }
 
 
@@ -1,38 +1,15 @@
 package Admin
 
 import (
-	"context"
 	"log"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/store"
 )
 
-var dbPool *pgxpool.Pool // shared across the Admin package
+var repo store.Repo // typed repositories for admins, drivers, vehicles, dispatches, trips, deliveries
 
-// InitDBPool initializes the DB connection pool
-func InitDBPool(connString string) error {
-	config, err := pgxpool.ParseConfig(connString)
-	if err != nil {
-		return err
-	}
-
-	// ✅ Fix for pgx v5: disable prepared statements globally
-	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
-
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
-	if err != nil {
-		return err
-	}
-
-	dbPool = pool
-	log.Println("[DB] Connection pool initialized")
-	return nil
+// InitStore wires the Admin package to the shared store
+func InitStore(s store.Repo) {
+	repo = s
+	log.Println("[DB] Admin store initialized")
 }
-
-// GetDB gives access to the dbPool
-func GetDB() *pgxpool.Pool {
-	return dbPool
-}
-
-
@@ -1,45 +1,27 @@
 package Admin
 
 import (
-	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	"github.com/mangochops/coninx_backend/Driver"
-
-	"github.com/twilio/twilio-go"
-	openapi "github.com/twilio/twilio-go/rest/verify/v2"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/idempotency"
+	"github.com/mangochops/coninx_backend/phone"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
 )
 
-type Dispatch struct {
-	ID        int    `json:"id"`
-	Recipient string `json:"recipient"` // ✅ corrected
-
-	Location string        `json:"location"`
-	Driver   Driver.Driver `json:"driver"`
-	Vehicle  Vehicle       `json:"vehicle"`
-	Invoice  int           `json:"invoice"`
-	Date     time.Time     `json:"date"`
-	Verified bool          `json:"verified"`
-}
+type Dispatch = store.Dispatch
 
 // var db *pgxpool.Pool
 
-// Twilio vars
-var (
-	accountSid string
-	authToken  string
-	serviceSid string
-	client     *twilio.RestClient
-)
-
 // func InitDB() {
 // 	dsn := os.Getenv("DATABASE_URL")
 // 	var err error
@@ -49,22 +31,40 @@ var (
 // 	}
 // }
 
-// Init Twilio + DB
+// Load env and hook up the arrival OTP
 func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, relying on system env")
 	}
 
-	accountSid = os.Getenv("TWILIO_ACCOUNT_SID")
-	authToken = os.Getenv("TWILIO_AUTH_TOKEN")
-	serviceSid = os.Getenv("TWILIO_VERIFY_SERVICE_SID")
+	// InitDB()
 
-	client = twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: accountSid,
-		Password: authToken,
-	})
+	if os.Getenv("AUTO_OTP_ON_ARRIVAL") == "true" {
+		tracking.OnArrival(sendArrivalOTP)
+	}
+}
 
-	// InitDB()
+var (
+	errDriverNotFound  = errors.New("driver not found")
+	errVehicleNotFound = errors.New("vehicle not found")
+)
+
+// lookupErr replaces store.ErrNotFound with a more specific error
+func lookupErr(err, notFound error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return notFound
+	}
+	return err
+}
+
+// validDestination checks optional destination coordinates and radius
+func validDestination(g *store.Geofence) bool {
+	if g == nil {
+		return true
+	}
+	return g.Latitude >= -90 && g.Latitude <= 90 &&
+		g.Longitude >= -180 && g.Longitude <= 180 &&
+		g.Radius >= 0
 }
 
 // Create a dispatch
@@ -74,51 +74,77 @@ func CreateDispatch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
-
-	// 🔑 Lookup driver_id by driver.id_number
-	var driverID int
-	err := dbPool.QueryRow(
-		context.Background(),
-		"SELECT id FROM drivers WHERE id_number=$1",
-		d.Driver.IDNumber,
-	).Scan(&driverID)
-	if err != nil {
-		http.Error(w, "Driver not found", http.StatusBadRequest)
+	if !validDestination(d.Destination) {
+		http.Error(w, "Invalid destination", http.StatusBadRequest)
 		return
 	}
-
-	// 🔑 Lookup vehicle_id by vehicle.reg_no
-	var vehicleID int
-	err = dbPool.QueryRow(
-		context.Background(),
-		"SELECT id FROM vehicles WHERE reg_no=$1",
-		d.Vehicle.RegNo,
-	).Scan(&vehicleID)
-	if err != nil {
-		http.Error(w, "Vehicle not found", http.StatusBadRequest)
+	if d.Phone == "" {
+		http.Error(w, "Recipient phone is required", http.StatusBadRequest)
 		return
 	}
-
-	// Insert dispatch
-	err = dbPool.QueryRow(
-		context.Background(),
-		`INSERT INTO dispatches (recipient, location, driver_id, vehicle_id, invoice, verified, date)
-         VALUES ($1, $2, $3, $4, $5, FALSE, NOW())
-         RETURNING id, date, verified`,
-		d.Recipient, d.Location, driverID, vehicleID, d.Invoice,
-	).Scan(&d.ID, &d.Date, &d.Verified)
+	normalized, err := phone.Normalize(d.Phone)
 	if err != nil {
-		http.Error(w, "Error inserting dispatch", http.StatusInternalServerError)
+		http.Error(w, "Invalid recipient phone", http.StatusBadRequest)
 		return
 	}
+	d.Phone = normalized
+
+	// Lookups, dispatch and trip share one transaction so a failed trip
+	// insert doesn't leave an orphan dispatch behind
+	var trip *Trips
+	err = repo.InTx(r.Context(), func(tx store.Repo) error {
+		// 🔑 Lookup driver_id by driver.id_number
+		driverID, err := tx.Drivers().IDByIDNumber(r.Context(), d.Driver.IDNumber)
+		if err != nil {
+			return lookupErr(err, errDriverNotFound)
+		}
 
-	// 🔑 Auto-create a trip for this dispatch
-	trip, err := AutoCreateTrip(d.ID, driverID, vehicleID, d.Location, d.Recipient)
-	if err != nil {
-		http.Error(w, "Dispatch created but trip creation failed: "+err.Error(), http.StatusInternalServerError)
+		// 🔑 Lookup vehicle_id by vehicle.reg_no
+		vehicleID, err := tx.Vehicles().IDByRegNo(r.Context(), d.Vehicle.RegNo)
+		if err != nil {
+			return lookupErr(err, errVehicleNotFound)
+		}
+
+		// Insert dispatch
+		if err := tx.Dispatches().Create(r.Context(), &d, driverID, vehicleID); err != nil {
+			return err
+		}
+
+		// 🔑 Auto-create a trip for this dispatch
+		trip, err = AutoCreateTrip(r.Context(), tx, d.ID, driverID, vehicleID, d.Location, d.Recipient)
+		if err != nil {
+			return err
+		}
+
+		// First entry in the dispatch's assignment history
+		return tx.Assignments().Open(r.Context(), &store.Assignment{
+			DispatchID: d.ID,
+			Driver:     store.Driver{ID: driverID},
+			Vehicle:    store.Vehicle{ID: vehicleID},
+			TripID:     trip.ID,
+			AssignedBy: auth.Actor(r.Context()),
+		})
+	})
+	switch {
+	case errors.Is(err, errDriverNotFound):
+		http.Error(w, "Driver not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errVehicleNotFound):
+		http.Error(w, "Vehicle not found", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create dispatch and trip: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Only announce the dispatch and trip once they're committed
+	events.Publish("dispatch_created", map[string]interface{}{
+		"dispatch": d,
+	})
+	events.Publish("trip_created", map[string]interface{}{
+		"trip": trip,
+	})
+
 	// Respond with both Dispatch and Trip
 	response := map[string]interface{}{
 		"dispatch": d,
@@ -132,46 +158,21 @@ func CreateDispatch(w http.ResponseWriter, r *http.Request) {
 
 // Get all dispatches (with driver + vehicle info)
 func GetDispatches(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbPool.Query(context.Background(), `
-		SELECT d.id, d.recipient, d.location, d.invoice, d.date, d.verified,
-		       dr.id_number, dr.first_name || ' ' || dr.last_name AS driver_name,
-		       v.reg_no
-		FROM dispatches d
-		LEFT JOIN drivers dr ON d.driver_id = dr.id
-		LEFT JOIN vehicles v ON d.vehicle_id = v.id
-		ORDER BY d.date DESC
-	`)
+	var dispatches []Dispatch
+	var err error
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		dispatches, err = repo.Dispatches().List(r.Context())
+	case store.DispatchPending, store.DispatchDelivered, store.DispatchPartial, store.DispatchFailed:
+		dispatches, err = repo.Dispatches().ListByStatus(r.Context(), status)
+	default:
+		http.Error(w, "Unknown status "+status, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var dispatches []Dispatch
-	for rows.Next() {
-		var d Dispatch
-		var driverIDNumber sql.NullInt64
-		var driverName sql.NullString
-		var vehicleReg sql.NullString
-
-		if err := rows.Scan(&d.ID, &d.Recipient, &d.Location, &d.Invoice, &d.Date, &d.Verified,
-			&driverIDNumber, &driverName, &vehicleReg); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if driverIDNumber.Valid {
-			d.Driver.IDNumber = int(driverIDNumber.Int64)
-		}
-		if driverName.Valid {
-			d.Driver.FirstName = driverName.String
-		}
-		if vehicleReg.Valid {
-			d.Vehicle.RegNo = vehicleReg.String
-		}
-
-		dispatches = append(dispatches, d)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(dispatches)
@@ -182,37 +183,12 @@ func GetDispatch(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, _ := strconv.Atoi(idStr)
 
-	var d Dispatch
-	var driverIDNumber sql.NullInt64
-	var driverName sql.NullString
-	var vehicleReg sql.NullString
-
-	err := dbPool.QueryRow(context.Background(), `
-		SELECT d.id, d.recipient, d.location, d.invoice, d.date, d.verified,
-		       dr.id_number, dr.first_name || ' ' || dr.last_name AS driver_name,
-		       v.reg_no
-		FROM dispatches d
-		LEFT JOIN drivers dr ON d.driver_id = dr.id
-		LEFT JOIN vehicles v ON d.vehicle_id = v.id
-		WHERE d.id=$1
-	`, id).Scan(&d.ID, &d.Recipient, &d.Location, &d.Invoice, &d.Date, &d.Verified,
-		&driverIDNumber, &driverName, &vehicleReg)
-
+	d, err := repo.Dispatches().Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Dispatch not found", http.StatusNotFound)
 		return
 	}
 
-	if driverIDNumber.Valid {
-		d.Driver.IDNumber = int(driverIDNumber.Int64)
-	}
-	if driverName.Valid {
-		d.Driver.FirstName = driverName.String
-	}
-	if vehicleReg.Valid {
-		d.Vehicle.RegNo = vehicleReg.String
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(d)
 }
@@ -226,47 +202,11 @@ func GetDispatchesByDriver(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := dbPool.Query(context.Background(), `
-		SELECT d.id, d.recipient, d.location, d.invoice, d.date, d.verified,
-		       dr.id_number, dr.first_name || ' ' || dr.last_name AS driver_name,
-		       v.reg_no
-		FROM dispatches d
-		LEFT JOIN drivers dr ON d.driver_id = dr.id
-		LEFT JOIN vehicles v ON d.vehicle_id = v.id
-		WHERE d.driver_id=$1
-		ORDER BY d.date DESC
-	`, driverID)
+	dispatches, err := repo.Dispatches().ListByDriver(r.Context(), driverID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var dispatches []Dispatch
-	for rows.Next() {
-		var d Dispatch
-		var driverIDNumber sql.NullInt64
-		var driverName sql.NullString
-		var vehicleReg sql.NullString
-
-		if err := rows.Scan(&d.ID, &d.Recipient, &d.Location, &d.Invoice, &d.Date, &d.Verified,
-			&driverIDNumber, &driverName, &vehicleReg); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if driverIDNumber.Valid {
-			d.Driver.IDNumber = int(driverIDNumber.Int64)
-		}
-		if driverName.Valid {
-			d.Driver.FirstName = driverName.String
-		}
-		if vehicleReg.Valid {
-			d.Vehicle.RegNo = vehicleReg.String
-		}
-
-		dispatches = append(dispatches, d)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(dispatches)
@@ -282,176 +222,96 @@ func UpdateDispatch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
-
-	// Lookup driver ID
-	var driverID int
-	err := dbPool.QueryRow(context.Background(),
-		"SELECT id FROM drivers WHERE id_number=$1", updated.Driver.IDNumber,
-	).Scan(&driverID)
-	if err != nil {
-		http.Error(w, "Driver not found", http.StatusBadRequest)
+	if !validDestination(updated.Destination) {
+		http.Error(w, "Invalid destination", http.StatusBadRequest)
 		return
 	}
 
-	// Lookup vehicle ID
-	var vehicleID int
-	err = dbPool.QueryRow(context.Background(),
-		"SELECT id FROM vehicles WHERE reg_no=$1", updated.Vehicle.RegNo,
-	).Scan(&vehicleID)
-	if err != nil {
-		http.Error(w, "Vehicle not found", http.StatusBadRequest)
+	current, err := repo.Dispatches().Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Dispatch not found", http.StatusNotFound)
 		return
 	}
-
-	// Update dispatch
-	_, err = dbPool.Exec(context.Background(),
-		`UPDATE dispatches 
-         SET recipient=$1, location=$2, invoice=$3, driver_id=$4, vehicle_id=$5
-         WHERE id=$6`,
-		updated.Recipient, updated.Location, updated.Invoice, driverID, vehicleID, id,
-	)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	updated.ID = id
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updated)
-}
-
-// Delete dispatch
-func DeleteDispatch(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
-
-	_, err := dbPool.Exec(context.Background(), `DELETE FROM dispatches WHERE id=$1`, id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Changing hands has to close the old trip and open a new one
+	if (updated.Driver.IDNumber != 0 && updated.Driver.IDNumber != current.Driver.IDNumber) ||
+		(updated.Vehicle.RegNo != "" && updated.Vehicle.RegNo != current.Vehicle.RegNo) {
+		http.Error(w, "Use POST /admin/dispatches/{id}/reassign to change the driver or vehicle", http.StatusConflict)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// ---------------- OTP Endpoints ----------------
-
-func SendOTP(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
 
-	var phone string
-	err := dbPool.QueryRow(context.Background(),
-		`SELECT phone FROM dispatches WHERE id=$1`, id).Scan(&phone)
-	if err != nil {
-		http.Error(w, "Dispatch not found", http.StatusNotFound)
+	// Phone is optional on update; omitting it keeps the current number
+	if updated.Phone == "" {
+		updated.Phone = current.Phone
+	} else if updated.Phone, err = phone.Normalize(updated.Phone); err != nil {
+		http.Error(w, "Invalid recipient phone", http.StatusBadRequest)
 		return
 	}
+	// Likewise the destination geofence
+	if updated.Destination == nil {
+		updated.Destination = current.Destination
+	}
 
-	params := &openapi.CreateVerificationParams{}
-	params.SetTo(phone)
-	params.SetChannel("sms")
-
-	resp, err := client.VerifyV2.CreateVerification(serviceSid, params)
-	if err != nil {
+	// Update dispatch
+	updated.ID = id
+	if err := repo.Dispatches().Update(r.Context(), &updated); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	updated.Driver = current.Driver
+	updated.Vehicle = current.Vehicle
+	updated.Date = current.Date
+	updated.Verified = current.Verified
+	updated.Status = current.Status
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": *resp.Status,
+	events.Publish("dispatch_updated", map[string]interface{}{
+		"dispatch": updated,
 	})
 }
 
-func VerifyOTP(w http.ResponseWriter, r *http.Request) {
+// Delete dispatch
+func DeleteDispatch(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
-	dispatchID, _ := strconv.Atoi(idStr)
-
-	var body struct {
-		Code string `json:"code"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
-	}
-
-	// Get phone number
-	var phone string
-	err := dbPool.QueryRow(context.Background(),
-		`SELECT phone FROM dispatches WHERE id=$1`, dispatchID).Scan(&phone)
-	if err != nil {
-		http.Error(w, "Dispatch not found", http.StatusNotFound)
-		return
-	}
-
-	// Verify OTP with Twilio
-	params := &openapi.CreateVerificationCheckParams{}
-	params.SetTo(phone)
-	params.SetCode(body.Code)
+	id, _ := strconv.Atoi(idStr)
 
-	resp, err := client.VerifyV2.CreateVerificationCheck(serviceSid, params)
-	if err != nil {
+	if err := repo.Dispatches().Delete(r.Context(), id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 
-	if *resp.Status != "approved" {
-		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
-		return
-	}
-
-	// ✅ Update dispatch as verified
-	_, err = dbPool.Exec(context.Background(),
-		`UPDATE dispatches SET verified=TRUE WHERE id=$1`, dispatchID)
-	if err != nil {
-		http.Error(w, "Failed to update dispatch: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// ✅ Mark trip as completed
-	var tripID int
-	err = dbPool.QueryRow(context.Background(),
-		`UPDATE trips SET status='completed', last_updated=NOW()
-		 WHERE dispatch_id=$1 RETURNING id`, dispatchID).Scan(&tripID)
-	if err != nil {
-		http.Error(w, "Failed to update trip: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// ✅ Auto-create delivery record
-	var deliveryID int
-	var deliveryDate time.Time
-	err = dbPool.QueryRow(context.Background(),
-		`INSERT INTO deliveries (dispatch_id, trip_id, date)
-		 VALUES ($1, $2, NOW())
-		 RETURNING id, date`,
-		dispatchID, tripID,
-	).Scan(&deliveryID, &deliveryDate)
-	if err != nil {
-		http.Error(w, "Failed to create delivery: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// ✅ Final response
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":  "OTP Verified ✅ Delivery completed",
-		"dispatch": dispatchID,
-		"trip":     tripID,
-		"delivery": map[string]interface{}{
-			"id":   deliveryID,
-			"date": deliveryDate,
-		},
+	events.Publish("dispatch_deleted", map[string]interface{}{
+		"dispatchId": id,
 	})
 }
 
 func RegisterDispatchRoutes(r *mux.Router) {
-	r.HandleFunc("/dispatches", CreateDispatch).Methods("POST")
+	r.HandleFunc("/dispatches", auth.RequirePermission(idempotency.Middleware(CreateDispatch), auth.PermDispatch)).Methods("POST")
 	r.HandleFunc("/dispatches", GetDispatches).Methods("GET")
 	r.HandleFunc("/dispatches/{id}", GetDispatch).Methods("GET")
-	r.HandleFunc("/dispatches/{id}", UpdateDispatch).Methods("PUT")
-	r.HandleFunc("/dispatches/{id}", DeleteDispatch).Methods("DELETE")
+	r.HandleFunc("/dispatches/{id}", auth.RequirePermission(UpdateDispatch, auth.PermDispatch)).Methods("PUT")
+	r.HandleFunc("/dispatches/{id}", auth.RequirePermission(DeleteDispatch, auth.PermDispatch)).Methods("DELETE")
 	r.HandleFunc("/drivers/{driverId}/dispatches", GetDispatchesByDriver).Methods("GET")
 
+	// Reassignment
+	r.HandleFunc("/dispatches/{id}/reassign", auth.RequirePermission(idempotency.Middleware(ReassignDispatch), auth.PermDispatch)).Methods("POST")
+	r.HandleFunc("/dispatches/{id}/assignments", GetDispatchAssignments).Methods("GET")
+	r.HandleFunc("/dispatches/{id}/reschedule", auth.RequirePermission(idempotency.Middleware(RescheduleDispatch), auth.PermDispatch)).Methods("POST")
+
 	// OTP routes
-	r.HandleFunc("/dispatches/{id}/send-otp", SendOTP).Methods("POST")
-	r.HandleFunc("/dispatches/{id}/verify-otp", VerifyOTP).Methods("POST")
+	r.HandleFunc("/dispatches/{id}/send-otp", auth.RequirePermission(SendOTP, auth.PermDispatch)).Methods("POST")
+	r.HandleFunc("/dispatches/{id}/verify-otp", auth.RequirePermission(idempotency.Middleware(VerifyOTP), auth.PermDispatch)).Methods("POST")
+	r.HandleFunc("/dispatches/{id}/otp-log", GetOTPLog).Methods("GET")
+
+	// Proof of delivery
+	r.HandleFunc("/dispatches/{id}/pod", GetDispatchPOD).Methods("GET")
+	r.HandleFunc("/dispatches/{id}/pod/{deliveryId:[0-9]+}/{kind:photo|signature}", GetDispatchPODFile).Methods("GET")
+	r.HandleFunc("/deliveries/{id}", auth.RequirePermission(DeleteDelivery, auth.PermDispatch)).Methods("DELETE")
 }
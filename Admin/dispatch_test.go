@@ -0,0 +1,55 @@
+package Admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+func TestUpdateDispatchKeepsOmittedFields(t *testing.T) {
+	ctx := context.Background()
+	s := storetest.New()
+	InitStore(s)
+	ds, _ := seedEnRoute(t, s)
+
+	dest := &store.Geofence{Latitude: -1.3, Longitude: 36.8, Radius: 200}
+	ds.Destination = dest
+	if err := s.Dispatches().Update(ctx, &ds); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Dispatches().SetStatus(ctx, ds.ID, store.DispatchFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"recipient":"Acme Ltd","location":"Westlands"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(ds.ID)})
+	w := httptest.NewRecorder()
+	UpdateDispatch(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", w.Code, w.Body.String())
+	}
+
+	var got store.Dispatch
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Recipient != "Acme Ltd" || got.Status != store.DispatchFailed || got.Destination == nil || *got.Destination != *dest {
+		t.Errorf("response = %+v (destination %v), want the new recipient with status and destination kept", got, got.Destination)
+	}
+
+	stored, err := s.Dispatches().Get(ctx, ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Destination == nil || *stored.Destination != *dest || stored.Phone != ds.Phone {
+		t.Errorf("stored destination %v, phone %q; want them kept", stored.Destination, stored.Phone)
+	}
+}
@@ -0,0 +1,298 @@
+package Admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/notify"
+	"github.com/mangochops/coninx_backend/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Single-use token purposes stored in admin_tokens.purpose
+const (
+	tokenInvite = "invite"
+	tokenReset  = "reset"
+)
+
+var (
+	InviteTTL = 72 * time.Hour
+	ResetTTL  = 1 * time.Hour
+)
+
+var notifier notify.Notifier = &notify.LogNotifier{}
+
+// InitNotifier sets how invite and reset emails are delivered
+func InitNotifier(n notify.Notifier) {
+	notifier = n
+}
+
+// dashboardLink builds a link into the admin dashboard carrying a token
+func dashboardLink(path, token string) string {
+	base := os.Getenv("ADMIN_APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// issueAdminToken stores a new single-use token and returns the raw value
+func issueAdminToken(ctx context.Context, purpose, email, role string, createdBy *int, ttl time.Duration) (string, time.Time, error) {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	err = repo.AdminTokens().Create(ctx, &store.AdminToken{
+		Purpose:   purpose,
+		Email:     email,
+		Role:      role,
+		TokenHash: hash,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// errAccountGone is returned when a reset token outlives the account it was issued for
+var errAccountGone = errors.New("account no longer exists")
+
+// --- InviteHandler ---
+// Owners invite a new admin by email with a role
+func InviteHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, `{"success":false,"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+	if body.Role == "" {
+		body.Role = auth.AdminViewer
+	}
+	if !auth.ValidAdminRole(body.Role) {
+		http.Error(w, `{"success":false,"message":"Invalid role"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	exists, err := repo.AdminUsers().Exists(ctx, body.Email)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Database error"}`, http.StatusInternalServerError)
+		log.Println("[Invite] Lookup error:", err)
+		return
+	}
+	if exists {
+		http.Error(w, `{"success":false,"message":"Email already registered"}`, http.StatusConflict)
+		return
+	}
+
+	claims, _ := auth.FromContext(r.Context())
+	invitedBy := claims.UserID()
+
+	token, expiresAt, err := issueAdminToken(ctx, tokenInvite, body.Email, body.Role, &invitedBy, InviteTTL)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Could not create invitation"}`, http.StatusInternalServerError)
+		log.Println("[Invite] Insert error:", err)
+		return
+	}
+
+	err = notifier.Send(ctx, notify.Message{
+		To:      body.Email,
+		Subject: "You've been invited to Coninx",
+		Body: fmt.Sprintf("You've been invited to the Coninx dashboard as %s.\n\nSet your password here (expires %s):\n%s\n",
+			body.Role, expiresAt.Format(time.RFC1123), dashboardLink("/accept-invite", token)),
+	})
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Invitation created but email failed"}`, http.StatusBadGateway)
+		log.Println("[Invite] Notify error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "Invitation sent",
+		Data: map[string]interface{}{
+			"email":     body.Email,
+			"role":      body.Role,
+			"expiresAt": expiresAt,
+		},
+	})
+
+	log.Println("[Invite] Invited:", body.Email, "as", body.Role)
+}
+
+// --- AcceptInviteHandler ---
+// Redeems an invitation token and creates the admin account
+func AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token     string `json:"token"`
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Password  string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" || body.Password == "" {
+		http.Error(w, `{"success":false,"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Error securing password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	a := AdminUser{FirstName: body.FirstName, LastName: body.LastName}
+	err = repo.InTx(ctx, func(tx store.Repo) error {
+		t, err := tx.AdminTokens().Consume(ctx, tokenInvite, auth.HashToken(body.Token))
+		if err != nil {
+			return err
+		}
+		a.Email, a.Role = t.Email, t.Role
+		return tx.AdminUsers().Create(ctx, &a, string(hashedPassword))
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, `{"success":false,"message":"Invitation is invalid, expired or already used"}`, http.StatusGone)
+		return
+	case errors.Is(err, store.ErrDuplicate):
+		http.Error(w, `{"success":false,"message":"Email already registered"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, `{"success":false,"message":"Database error"}`, http.StatusInternalServerError)
+		log.Println("[AcceptInvite] Error:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "Account created, you can now log in",
+		Data:    a,
+	})
+
+	log.Println("[AcceptInvite] Admin created:", a.Email)
+}
+
+// --- ForgotPasswordHandler ---
+// Always answers the same way so emails can't be probed
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, `{"success":false,"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	exists, err := repo.AdminUsers().Exists(ctx, body.Email)
+	if err != nil {
+		log.Println("[ForgotPassword] Lookup error:", err)
+	}
+
+	if exists {
+		token, expiresAt, err := issueAdminToken(ctx, tokenReset, body.Email, "", nil, ResetTTL)
+		if err != nil {
+			log.Println("[ForgotPassword] Insert error:", err)
+		} else {
+			err = notifier.Send(ctx, notify.Message{
+				To:      body.Email,
+				Subject: "Reset your Coninx password",
+				Body: fmt.Sprintf("Someone asked to reset your Coninx password.\n\nReset it here (expires %s):\n%s\n\nIf this wasn't you, ignore this email.\n",
+					expiresAt.Format(time.RFC1123), dashboardLink("/reset-password", token)),
+			})
+			if err != nil {
+				log.Println("[ForgotPassword] Notify error:", err)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "If that email is registered, a reset link has been sent",
+	})
+}
+
+// --- ResetPasswordHandler ---
+// Redeems a reset token, sets the new password and signs out every session
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" || body.Password == "" {
+		http.Error(w, `{"success":false,"message":"Invalid input"}`, http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"success":false,"message":"Error securing password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var email string
+	var adminID int
+	err = repo.InTx(ctx, func(tx store.Repo) error {
+		t, err := tx.AdminTokens().Consume(ctx, tokenReset, auth.HashToken(body.Token))
+		if err != nil {
+			return err
+		}
+		email = t.Email
+		adminID, err = tx.AdminUsers().SetPassword(ctx, email, string(hashedPassword))
+		if errors.Is(err, store.ErrNotFound) {
+			return errAccountGone
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, `{"success":false,"message":"Reset link is invalid, expired or already used"}`, http.StatusGone)
+		return
+	case errors.Is(err, errAccountGone):
+		http.Error(w, `{"success":false,"message":"Account no longer exists"}`, http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, `{"success":false,"message":"Database error"}`, http.StatusInternalServerError)
+		log.Println("[ResetPassword] Error:", err)
+		return
+	}
+
+	if _, err := auth.RevokeAllSessions(ctx, auth.RoleAdmin, adminID); err != nil {
+		log.Println("[ResetPassword] Revoke error:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Message: "Password updated, please log in again"})
+
+	log.Println("[ResetPassword] Password reset:", email)
+}
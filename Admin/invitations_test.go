@@ -0,0 +1,70 @@
+package Admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+func TestAcceptInvite(t *testing.T) {
+	s := storetest.New()
+	InitStore(s)
+	ctx := context.Background()
+
+	token, _, err := issueAdminToken(ctx, tokenInvite, "new@example.com", auth.AdminDispatcher, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accept := func(token string) int {
+		body := `{"token":"` + token + `","firstName":"New","password":"s3cret-pass"}`
+		w := httptest.NewRecorder()
+		AcceptInviteHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := accept("not-a-token"); code != http.StatusGone {
+		t.Errorf("unknown token: got %d, want 410", code)
+	}
+	if code := accept(token); code != http.StatusCreated {
+		t.Fatalf("first accept: got %d, want 201", code)
+	}
+	if code := accept(token); code != http.StatusGone {
+		t.Errorf("second accept: got %d, want 410", code)
+	}
+
+	_, role, _, err := s.AdminUsers().Credentials(ctx, "new@example.com")
+	if err != nil || role != auth.AdminDispatcher {
+		t.Errorf("created admin role = %q (%v), want %q", role, err, auth.AdminDispatcher)
+	}
+}
+
+func TestAcceptInviteRollsBackOnDuplicate(t *testing.T) {
+	s := storetest.New()
+	InitStore(s)
+	ctx := context.Background()
+	seedAdmins(t, s, auth.AdminOwner) // admin0@example.com
+
+	token, _, err := issueAdminToken(ctx, tokenInvite, "admin0@example.com", auth.AdminViewer, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"token":"` + token + `","password":"s3cret-pass"}`
+	w := httptest.NewRecorder()
+	AcceptInviteHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d %q, want 409", w.Code, w.Body.String())
+	}
+
+	// The failed insert must not use up the invitation
+	if _, err := s.AdminTokens().Consume(ctx, tokenInvite, auth.HashToken(token)); err != nil {
+		t.Errorf("token consumed by a rolled-back accept: %v", err)
+	}
+}
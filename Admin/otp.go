@@ -0,0 +1,423 @@
+package Admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/otp"
+	"github.com/mangochops/coninx_backend/phone"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// otpProvider issues and checks the recipient's delivery code
+var otpProvider otp.Provider
+
+// InitOTP sets how delivery OTPs are sent and verified
+func InitOTP(p otp.Provider) {
+	otpProvider = p
+}
+
+// otpRef names the code that unlocks a dispatch
+func otpRef(dispatchID int) string {
+	return "dispatch:" + strconv.Itoa(dispatchID)
+}
+
+// OTP limits per dispatch, enforced whichever provider is configured
+var (
+	OTPResendCooldown    = time.Minute      // between two sends
+	OTPMaxSends          = 5                // sends per OTPSendWindow
+	OTPSendWindow        = time.Hour        // window for OTPMaxSends
+	OTPMaxVerifyAttempts = 5                // wrong codes before a new one must be sent
+	OTPCodeTTL           = 10 * time.Minute // how long a sent code is accepted
+)
+
+// otpRateLimitError is returned when a send is refused
+type otpRateLimitError struct {
+	retryAfter time.Duration
+	reason     string
+}
+
+func (e *otpRateLimitError) Error() string {
+	return e.reason
+}
+
+// ---------------- OTP Endpoints ----------------
+
+func SendOTP(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["id"]
+	id, _ := strconv.Atoi(idStr)
+
+	status, err := sendDispatchOTP(r.Context(), id, auth.Actor(r.Context()))
+	var rl *otpRateLimitError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Dispatch not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errBadRecipientPhone):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.As(err, &rl):
+		w.Header().Set("Retry-After", strconv.Itoa(int(rl.retryAfter.Seconds()+0.999)))
+		http.Error(w, rl.reason, http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": status,
+	})
+}
+
+// errBadRecipientPhone is returned when a dispatch's number can't be used for OTP
+var errBadRecipientPhone = errors.New("recipient phone number is missing or invalid; update the dispatch")
+
+// recipientPhone returns the dispatch's recipient number in E.164. Rows saved
+// before numbers were normalised may still hold a local format, so it is
+// normalised again here rather than trusted.
+func recipientPhone(ctx context.Context, tx store.Repo, dispatchID int) (string, error) {
+	raw, err := tx.Dispatches().Phone(ctx, dispatchID)
+	if err != nil {
+		return "", err
+	}
+	to, err := phone.Normalize(raw)
+	if err != nil {
+		return "", errBadRecipientPhone
+	}
+	return to, nil
+}
+
+// sendDispatchOTP texts a verification code to the dispatch recipient, subject
+// to the resend cooldown and send limit. Every attempt is logged.
+//
+// The slot is reserved in a short transaction and the provider is called
+// outside it, so a slow provider holds neither the dispatch lock nor a pool
+// connection. A reservation left behind by a crash counts against the limits
+// until it ages out of OTPSendWindow.
+func sendDispatchOTP(ctx context.Context, dispatchID int, actor string) (string, error) {
+	entry := store.OTPAttempt{DispatchID: dispatchID, Action: store.OTPSend, Actor: actor}
+	var limitErr error // refused without calling the provider
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		// Serialises sends for the dispatch so the limits hold under concurrent requests
+		if err := tx.Dispatches().Lock(ctx, dispatchID); err != nil {
+			return err
+		}
+		to, err := recipientPhone(ctx, tx, dispatchID)
+		entry.Phone = to
+		if errors.Is(err, errBadRecipientPhone) {
+			limitErr = err
+			entry.Outcome = store.OTPSendFailed
+			detail := err.Error()
+			entry.Detail = &detail
+			return tx.OTPLog().Record(ctx, &entry)
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		last, err := tx.OTPLog().LastSent(ctx, dispatchID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		sent, err := tx.OTPLog().CountSent(ctx, dispatchID, now.Add(-OTPSendWindow))
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case last != nil && now.Sub(last.CreatedAt) < OTPResendCooldown:
+			limitErr = &otpRateLimitError{
+				retryAfter: OTPResendCooldown - now.Sub(last.CreatedAt),
+				reason:     "A code was just sent; wait before requesting another",
+			}
+		case sent >= OTPMaxSends:
+			limitErr = &otpRateLimitError{
+				retryAfter: OTPSendWindow,
+				reason:     fmt.Sprintf("At most %d codes can be sent per %s", OTPMaxSends, OTPSendWindow),
+			}
+		}
+
+		if limitErr != nil {
+			entry.Outcome = store.OTPRateLimited
+			detail := limitErr.Error()
+			entry.Detail = &detail
+		} else {
+			entry.Outcome = store.OTPSending
+			expires := now.Add(OTPCodeTTL)
+			entry.ExpiresAt = &expires
+		}
+		return tx.OTPLog().Record(ctx, &entry)
+	})
+	if err != nil {
+		return "", err
+	}
+	if limitErr != nil {
+		return "", limitErr
+	}
+
+	status, sendErr := otpProvider.Send(ctx, otpRef(dispatchID), entry.Phone)
+
+	outcome := store.OTPSent
+	var detail *string
+	if sendErr != nil {
+		outcome = store.OTPSendFailed
+		msg := sendErr.Error()
+		detail = &msg
+	}
+	// Settle the reservation even if the client has gone away
+	if err := repo.OTPLog().Finish(context.WithoutCancel(ctx), entry.ID, outcome, detail); err != nil {
+		return "", fmt.Errorf("record OTP send: %w", err)
+	}
+	return status, sendErr
+}
+
+// sendArrivalOTP texts the recipient as soon as the trip enters the destination geofence
+func sendArrivalOTP(ctx context.Context, t *store.Trip) {
+	if _, err := sendDispatchOTP(ctx, t.DispatchID, "system"); err != nil {
+		log.Printf("[OTP] Arrival OTP for dispatch %d failed: %v\n", t.DispatchID, err)
+		return
+	}
+	log.Printf("[OTP] Sent arrival OTP for dispatch %d\n", t.DispatchID)
+}
+
+// errNoActiveTrip is returned when a dispatch has no trip left to deliver
+var errNoActiveTrip = errors.New("no active trip for this dispatch")
+
+func VerifyOTP(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["id"]
+	dispatchID, _ := strconv.Atoi(idStr)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	actor := auth.Actor(ctx)
+	entry := store.OTPAttempt{DispatchID: dispatchID, Action: store.OTPVerify, Actor: actor}
+	var checkErr, completeErr error
+	var tripID int
+	delivery := store.Delivery{DispatchID: dispatchID}
+
+	// Like sends, the provider is called between two short transactions: it
+	// may spend the code on its own connection, and must not wait on the lock
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		// Serialises attempts for the dispatch so the attempt limit can't be raced
+		if err := tx.Dispatches().Lock(ctx, dispatchID); err != nil {
+			return err
+		}
+		to, err := recipientPhone(ctx, tx, dispatchID)
+		if err != nil {
+			return err
+		}
+		entry.Phone = to
+
+		// Don't spend the recipient's code on a dispatch that can't be delivered
+		trip, err := tx.Trips().ActiveForDispatch(ctx, dispatchID)
+		if errors.Is(err, store.ErrNotFound) {
+			return errNoActiveTrip
+		}
+		if err != nil {
+			return err
+		}
+		if !store.CanTransition(trip.Status, store.TripDelivered) {
+			return &store.TransitionError{From: trip.Status, To: store.TripDelivered}
+		}
+
+		last, err := tx.OTPLog().LastSent(ctx, dispatchID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		failed, err := tx.OTPLog().FailedVerifies(ctx, dispatchID)
+		if err != nil {
+			return err
+		}
+
+		// Refusals are settled here without asking the provider
+		switch {
+		case failed >= OTPMaxVerifyAttempts:
+			entry.Outcome = store.OTPLocked
+		case last != nil && last.ExpiresAt != nil && time.Now().After(*last.ExpiresAt):
+			entry.Outcome = store.OTPExpired
+		default:
+			return nil
+		}
+		return tx.OTPLog().Record(ctx, &entry)
+	})
+	var te *store.TransitionError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Dispatch not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errBadRecipientPhone):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, errNoActiveTrip), errors.As(err, &te):
+		http.Error(w, "Failed to complete delivery: "+err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if entry.Outcome == "" {
+		ok, err := otpProvider.Check(ctx, otpRef(dispatchID), entry.Phone, body.Code)
+		switch {
+		case errors.Is(err, otp.ErrNoCode):
+			entry.Outcome = store.OTPExpired
+		case errors.Is(err, otp.ErrTooManyAttempts):
+			entry.Outcome = store.OTPLocked
+		case err != nil:
+			entry.Outcome = store.OTPError
+			checkErr = err
+			detail := err.Error()
+			entry.Detail = &detail
+		case !ok:
+			entry.Outcome = store.OTPRejected
+		default:
+			entry.Outcome = store.OTPApproved
+		}
+
+		// Record the outcome even if the client has gone away
+		ctx := context.WithoutCancel(ctx)
+		err = repo.InTx(ctx, func(tx store.Repo) error {
+			if err := tx.Dispatches().Lock(ctx, dispatchID); err != nil {
+				return err
+			}
+			// Attempts checked alongside this one may have used up the limit
+			failed, err := tx.OTPLog().FailedVerifies(ctx, dispatchID)
+			if err != nil {
+				return err
+			}
+			if failed >= OTPMaxVerifyAttempts {
+				entry.Outcome = store.OTPLocked
+			}
+
+			if entry.Outcome == store.OTPApproved {
+				// Savepoint: if completing fails the approval is still on record
+				completeErr = tx.InTx(ctx, func(tx store.Repo) error {
+					// The trip may have changed while the code was checked
+					trip, err := tx.Trips().ActiveForDispatch(ctx, dispatchID)
+					if errors.Is(err, store.ErrNotFound) {
+						return errNoActiveTrip
+					}
+					if err != nil {
+						return err
+					}
+					tripID = trip.ID
+
+					// ✅ Update dispatch as verified
+					if err := tx.Dispatches().MarkVerified(ctx, dispatchID); err != nil {
+						return fmt.Errorf("update dispatch: %w", err)
+					}
+
+					// ✅ Mark trip as delivered
+					if _, err := tx.Trips().Transition(ctx, tripID, store.TripDelivered, actor, ""); err != nil {
+						return fmt.Errorf("update trip: %w", err)
+					}
+
+					// ✅ Auto-create delivery record
+					delivery.TripID = tripID
+					if err := tx.Deliveries().Create(ctx, &delivery); err != nil {
+						return fmt.Errorf("create delivery: %w", err)
+					}
+					return nil
+				})
+				if completeErr != nil {
+					detail := "delivery not completed: " + completeErr.Error()
+					entry.Detail = &detail
+				}
+			}
+			return tx.OTPLog().Record(ctx, &entry)
+		})
+		if err != nil {
+			http.Error(w, "Failed to record OTP check: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	switch entry.Outcome {
+	case store.OTPLocked:
+		http.Error(w, "Too many wrong codes, request a new one", http.StatusTooManyRequests)
+		return
+	case store.OTPExpired:
+		http.Error(w, "OTP expired or not sent, request a new one", http.StatusUnauthorized)
+		return
+	case store.OTPError:
+		http.Error(w, checkErr.Error(), http.StatusInternalServerError)
+		return
+	case store.OTPRejected:
+		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case errors.As(completeErr, &te), errors.Is(completeErr, errNoActiveTrip):
+		http.Error(w, "Failed to complete delivery: "+completeErr.Error(), http.StatusConflict)
+		return
+	case completeErr != nil:
+		http.Error(w, "Failed to complete delivery: "+completeErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events.Publish("trip_completed", map[string]interface{}{
+		"tripId": tripID,
+		"status": store.TripDelivered,
+	})
+	events.Publish("delivery_created", map[string]interface{}{
+		"delivery": delivery,
+	})
+
+	// ✅ Final response
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "OTP Verified ✅ Delivery completed",
+		"dispatch": dispatchID,
+		"trip":     tripID,
+		"delivery": map[string]interface{}{
+			"id":   delivery.ID,
+			"date": delivery.Date,
+		},
+	})
+}
+
+// GetOTPLog lists every OTP send and verify attempt for a dispatch, for
+// resolving disputes with recipients
+func GetOTPLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := repo.Dispatches().Get(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Dispatch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := repo.OTPLog().ListByDispatch(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
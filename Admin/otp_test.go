@@ -0,0 +1,145 @@
+package Admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/otp"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+// txCounter reports how many transactions are open on the wrapped store
+type txCounter struct {
+	store.Repo
+	open int
+}
+
+func (c *txCounter) InTx(ctx context.Context, fn func(tx store.Repo) error) error {
+	c.open++
+	defer func() { c.open-- }()
+	return c.Repo.InTx(ctx, fn)
+}
+
+// checkSpy is the fake provider, noting the open transactions on each Check
+// and running during, if set, while the code is checked
+type checkSpy struct {
+	otp.FakeProvider
+	repo     *txCounter
+	openSeen []int
+	during   func()
+}
+
+func (p *checkSpy) Check(ctx context.Context, ref, phone, code string) (bool, error) {
+	p.openSeen = append(p.openSeen, p.repo.open)
+	if p.during != nil {
+		p.during()
+	}
+	return p.FakeProvider.Check(ctx, ref, phone, code)
+}
+
+// seedEnRoute creates a dispatch with a recipient phone and a trip under way
+func seedEnRoute(t *testing.T, s *storetest.Store) (store.Dispatch, *store.Trip) {
+	t.Helper()
+	ctx := context.Background()
+
+	d := store.Driver{FirstName: "Jane", LastName: "Doe", IDNumber: 12345678}
+	if err := s.Drivers().Create(ctx, &d, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	v := store.Vehicle{Type: "van", RegNo: "KAA 123A"}
+	if err := s.Vehicles().Create(ctx, &v); err != nil {
+		t.Fatal(err)
+	}
+	ds := store.Dispatch{Recipient: "Acme", Phone: "+254712345678", Location: "Westlands"}
+	if err := s.Dispatches().Create(ctx, &ds, d.ID, v.ID); err != nil {
+		t.Fatal(err)
+	}
+	trip, err := s.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, next := range []string{store.TripAccepted, store.TripEnRoute} {
+		if trip, err = s.Trips().Transition(ctx, trip.ID, next, "test", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds, trip
+}
+
+func TestVerifyOTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		lockedMidway bool // other attempts use up the limit while this one is checked
+		wantCode     int
+		wantOutcome  string
+		wantStatus   string // trip status afterwards
+	}{
+		{name: "right code", code: "000000",
+			wantCode: http.StatusOK, wantOutcome: store.OTPApproved, wantStatus: store.TripDelivered},
+		{name: "wrong code", code: "111111",
+			wantCode: http.StatusUnauthorized, wantOutcome: store.OTPRejected, wantStatus: store.TripEnRoute},
+		{name: "limit reached during the check", code: "000000", lockedMidway: true,
+			wantCode: http.StatusTooManyRequests, wantOutcome: store.OTPLocked, wantStatus: store.TripEnRoute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := storetest.New()
+			counter := &txCounter{Repo: s}
+			InitStore(counter)
+			ds, trip := seedEnRoute(t, s)
+
+			spy := &checkSpy{repo: counter}
+			InitOTP(spy)
+			if _, err := spy.Send(ctx, otpRef(ds.ID), ds.Phone); err != nil {
+				t.Fatal(err)
+			}
+			if tt.lockedMidway {
+				spy.during = func() {
+					for range OTPMaxVerifyAttempts {
+						a := store.OTPAttempt{DispatchID: ds.ID, Action: store.OTPVerify, Outcome: store.OTPRejected, Actor: "test"}
+						if err := s.OTPLog().Record(ctx, &a); err != nil {
+							t.Error(err)
+						}
+					}
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"code":"`+tt.code+`"}`))
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(ds.ID)})
+			w := httptest.NewRecorder()
+			VerifyOTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			if len(spy.openSeen) != 1 || spy.openSeen[0] != 0 {
+				t.Errorf("transactions open during Check = %v, want [0]", spy.openSeen)
+			}
+
+			log, err := s.OTPLog().ListByDispatch(ctx, ds.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := log[len(log)-1]
+			if last.Action != store.OTPVerify || last.Outcome != tt.wantOutcome {
+				t.Errorf("last attempt = %s/%s, want verify/%s", last.Action, last.Outcome, tt.wantOutcome)
+			}
+			after, err := s.Trips().Get(ctx, trip.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Status != tt.wantStatus {
+				t.Errorf("trip status = %q, want %q", after.Status, tt.wantStatus)
+			}
+		})
+	}
+}
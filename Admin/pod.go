@@ -0,0 +1,182 @@
+package Admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/blob"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+var blobs blob.Store
+
+// InitBlobs sets where proof-of-delivery files are read from
+func InitBlobs(b blob.Store) {
+	blobs = b
+}
+
+// podDelivery is a delivery with links to its stored files
+type podDelivery struct {
+	store.Delivery
+	PhotoURL     string `json:"photoUrl,omitempty"`
+	SignatureURL string `json:"signatureUrl,omitempty"`
+}
+
+// GetDispatchPOD returns the proof-of-delivery bundle for a dispatch: the
+// dispatch, every delivery recorded against it with links to its photo and
+// signature, and the approved OTP verification if there was one
+func GetDispatchPOD(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	d, err := repo.Dispatches().Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Dispatch not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list, err := repo.Deliveries().ListByDispatch(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deliveries := make([]podDelivery, 0, len(list))
+	for _, del := range list {
+		pd := podDelivery{Delivery: del}
+		if del.HasPhoto {
+			pd.PhotoURL = fmt.Sprintf("/admin/dispatches/%d/pod/%d/photo", id, del.ID)
+		}
+		if del.HasSignature {
+			pd.SignatureURL = fmt.Sprintf("/admin/dispatches/%d/pod/%d/signature", id, del.ID)
+		}
+		deliveries = append(deliveries, pd)
+	}
+
+	attempts, err := repo.OTPLog().ListByDispatch(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var verification *store.OTPAttempt
+	for i := range attempts {
+		if attempts[i].Action == store.OTPVerify && attempts[i].Outcome == store.OTPApproved {
+			verification = &attempts[i]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dispatch":     d,
+		"deliveries":   deliveries,
+		"verification": verification,
+	})
+}
+
+// GetDispatchPODFile streams a delivery's photo or signature
+func GetDispatchPODFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dispatchID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	deliveryID, err := strconv.Atoi(vars["deliveryId"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	del, err := repo.Deliveries().Get(r.Context(), deliveryID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && del.DispatchID != dispatchID) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	key := del.PhotoKey
+	if vars["kind"] == "signature" {
+		key = del.SignatureKey
+	}
+	if key == "" {
+		http.Error(w, "No "+vars["kind"]+" for this delivery", http.StatusNotFound)
+		return
+	}
+	if blobs == nil {
+		http.Error(w, "Blob store not initialized", http.StatusInternalServerError)
+		return
+	}
+
+	body, contentType, err := blobs.Get(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		http.Error(w, "File missing from storage", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("[POD] Failed to stream %s: %v\n", key, err)
+	}
+}
+
+// DeleteDelivery removes a delivery record and its stored photo and signature
+func DeleteDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	var del *store.Delivery
+	err = repo.InTx(r.Context(), func(tx store.Repo) error {
+		var err error
+		if del, err = tx.Deliveries().Get(r.Context(), id); err != nil {
+			return err
+		}
+		return tx.Deliveries().Delete(r.Context(), id)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The row is gone either way; a file left behind is only logged
+	for _, key := range []string{del.PhotoKey, del.SignatureKey} {
+		if key == "" || blobs == nil {
+			continue
+		}
+		if err := blobs.Delete(r.Context(), key); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			log.Printf("[POD] Failed to remove %s for delivery %d: %v\n", key, id, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,122 +3,47 @@ package Admin
 import (
 	"context"
 	"encoding/json"
-
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/mangochops/coninx_backend/Driver"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
 )
 
 // Trips represents a delivery trip persisted in DB
-type Trips struct {
-	ID            int           `json:"id"`
-	DispatchID    int           `json:"dispatch_id"`
-	Dispatch      *Dispatch     `json:"dispatch,omitempty"`
-	Driver        Driver.Driver `json:"driver"`
-	Vehicle       Vehicle       `json:"vehicle"`
-	Destination   string        `json:"destination"`
-	RecipientName string        `json:"recipient_name"`
-	Status        string        `json:"status"`
-	Latitude      float64       `json:"latitude"`
-	Longitude     float64       `json:"longitude"`
-	LastUpdated   time.Time     `json:"lastUpdated"`
-}
-
-// ---------------- SSE broadcaster ----------------
-
-type sseClient chan []byte
-
-var (
-	sseClients   = make(map[sseClient]bool)
-	sseClientsMu sync.Mutex
-)
-
-// broadcastToSSE sends the given payload to all connected SSE clients.
-func broadcastToSSE(payload interface{}) {
-	b, err := json.Marshal(payload)
-	if err != nil {
-		log.Println("broadcast marshal error:", err)
-		return
-	}
-
-	sseClientsMu.Lock()
-	defer sseClientsMu.Unlock()
-	for ch := range sseClients {
-		select {
-		case ch <- b:
-		default:
-			// Drop if client is too slow
-		}
-	}
-}
-
-// sseHandler handles new SSE client connections.
+type Trips = store.Trip
 
 // ---------------- CRUD ----------------
 
-// AutoCreateTrip is called by CreateDispatch to attach a trip automatically
-func AutoCreateTrip(dispatchID int, driverID int, vehicleID int, destination string, recipientName string) (*Trips, error) {
-	var t Trips
-	err := dbPool.QueryRow(
-		context.Background(),
-		`INSERT INTO trips (dispatch_id, driver_id, vehicle_id, destination, recipient_name, status, latitude, longitude, last_updated)
-		 VALUES ($1, $2, $3, $4, $5, 'started', 0, 0, NOW())
-		 RETURNING id, dispatch_id, driver_id, vehicle_id, destination, recipient_name, status, latitude, longitude, last_updated`,
-		dispatchID, driverID, vehicleID, destination, recipientName,
-	).Scan(
-		&t.ID,
-		&t.DispatchID,
-		&t.Driver.IDNumber,
-		&t.Vehicle.ID,
-		&t.Destination,
-		&t.RecipientName,
-		&t.Status,
-		&t.Latitude,
-		&t.Longitude,
-		&t.LastUpdated,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	// broadcast new trip
-	broadcastToSSE(map[string]interface{}{
-		"type": "trip_created",
-		"trip": t,
-	})
-
-	return &t, nil
+// AutoCreateTrip is called by CreateDispatch to attach a trip automatically.
+// tx is the dispatch's transaction; the caller broadcasts trip_created after commit.
+func AutoCreateTrip(ctx context.Context, tx store.Repo, dispatchID int, driverID int, vehicleID int, destination string, recipientName string) (*Trips, error) {
+	return tx.Trips().Create(ctx, dispatchID, driverID, vehicleID, destination, recipientName)
 }
 
-func GetTrips(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbPool.Query(context.Background(),
-		`SELECT id, dispatch_id, driver_id, vehicle_id, status, latitude, longitude, last_updated 
-		 FROM trips WHERE status != 'completed'`)
+// writeTrips lists trips matching f
+func writeTrips(w http.ResponseWriter, r *http.Request, f store.TripFilter) {
+	res, err := repo.Trips().List(r.Context(), f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var res []Trips
-	for rows.Next() {
-		var t Trips
-		if err := rows.Scan(&t.ID, &t.DispatchID, &t.Driver.IDNumber, &t.Vehicle.ID,
-			&t.Status, &t.Latitude, &t.Longitude, &t.LastUpdated); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		res = append(res, t)
-	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
+// GetTrips lists active trips, plus the last trip of each failed or partly
+// delivered dispatch until it is rescheduled
+func GetTrips(w http.ResponseWriter, r *http.Request) {
+	writeTrips(w, r, store.TripFilter{Open: true})
+}
+
 func GetTrip(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
@@ -127,13 +52,7 @@ func GetTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var t Trips
-	err = dbPool.QueryRow(context.Background(),
-		`SELECT id, dispatch_id, driver_id, vehicle_id, status, latitude, longitude, last_updated
-		 FROM trips WHERE id=$1`, id,
-	).Scan(&t.ID, &t.DispatchID, &t.Driver.IDNumber, &t.Vehicle.ID,
-		&t.Status, &t.Latitude, &t.Longitude, &t.LastUpdated)
-
+	t, err := repo.Trips().Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Trip not found", http.StatusNotFound)
 		return
@@ -151,30 +70,41 @@ func GetTripsByDriver(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := dbPool.Query(context.Background(),
-		`SELECT id, dispatch_id, driver_id, vehicle_id, status, latitude, longitude, last_updated
-		 FROM trips WHERE driver_id=$1`, driverID)
-	if err != nil {
+	writeTrips(w, r, store.TripFilter{DriverID: driverID})
+}
+
+// writeTripError maps trip store errors to responses; illegal status changes are 409s
+func writeTripError(w http.ResponseWriter, err error) {
+	var te *store.TransitionError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Trip not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrInvalidStatus), errors.Is(err, tracking.ErrInvalidFix):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &te):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
-	defer rows.Close()
+}
 
-	var res []Trips
-	for rows.Next() {
-		var t Trips
-		if err := rows.Scan(&t.ID, &t.DispatchID, &t.Driver.IDNumber, &t.Vehicle.ID,
-			&t.Status, &t.Latitude, &t.Longitude, &t.LastUpdated); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		res = append(res, t)
+// settleDispatch records a trip's outcome on its dispatch: delivered, or failed
+// so it can be rescheduled. Other statuses leave the dispatch alone.
+func settleDispatch(ctx context.Context, tx store.Repo, t *Trips) error {
+	if t.DispatchID == 0 {
+		return nil
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	switch t.Status {
+	case store.TripDelivered:
+		return tx.Dispatches().SetStatus(ctx, t.DispatchID, store.DispatchDelivered)
+	case store.TripFailed:
+		return tx.Dispatches().SetStatus(ctx, t.DispatchID, store.DispatchFailed)
+	}
+	return nil
 }
 
+// UpdateTrip moves a trip and/or changes its status. Status changes must
+// follow the trip lifecycle; delivered and failed also settle the dispatch.
 func UpdateTrip(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
@@ -183,29 +113,78 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var updated Trips
-	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+	var body Trips
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	_, err = dbPool.Exec(context.Background(),
-		`UPDATE trips SET status=$1, latitude=$2, longitude=$3, last_updated=NOW()
-		 WHERE id=$4`,
-		updated.Status, updated.Latitude, updated.Longitude, id,
-	)
+	// Refuse the whole update before anything is moved or broadcast; the
+	// status change is checked again under the dispatch lock below
+	current, err := repo.Trips().Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTripError(w, err)
+		return
+	}
+	moves := body.Latitude != 0 || body.Longitude != 0
+	if body.Status != "" && body.Status != current.Status {
+		if !store.ValidTripStatus(body.Status) {
+			writeTripError(w, store.ErrInvalidStatus)
+			return
+		}
+		if !store.CanTransition(current.Status, body.Status) {
+			writeTripError(w, &store.TransitionError{From: current.Status, To: body.Status})
+			return
+		}
+	}
+	if moves && store.TripIsFinal(current.Status) {
+		http.Error(w, "Trip ended as "+current.Status+"; it can't be moved", http.StatusConflict)
+		return
+	}
+
+	// Moves go through tracking like any other fix: breadcrumb, live map
+	// update and geofence check
+	if moves {
+		pos := store.Position{Latitude: body.Latitude, Longitude: body.Longitude}
+		if _, err := tracking.UpdateTripLocation(r.Context(), id, 0, pos); err != nil {
+			writeTripError(w, err)
+			return
+		}
+	}
+
+	var updated *Trips
+	statusChanged := false
+	err = repo.InTx(r.Context(), func(tx store.Repo) error {
+		var err error
+		updated, err = tx.Trips().Get(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		if body.Status != "" && body.Status != updated.Status {
+			if updated.DispatchID != 0 {
+				if err := tx.Dispatches().Lock(r.Context(), updated.DispatchID); err != nil {
+					return err
+				}
+			}
+			if updated, err = tx.Trips().Transition(r.Context(), id, body.Status, auth.Actor(r.Context()), ""); err != nil {
+				return err
+			}
+			statusChanged = true
+			return settleDispatch(r.Context(), tx, updated)
+		}
+		return nil
+	})
+	if err != nil {
+		writeTripError(w, err)
 		return
 	}
 
-	updated.ID = id
-	updated.LastUpdated = time.Now()
 	json.NewEncoder(w).Encode(updated)
 
-	broadcastToSSE(map[string]interface{}{
-		"type": "trip_updated",
-		"trip": updated,
+	events.Publish("trip_updated", map[string]interface{}{
+		"trip":          updated,
+		"statusChanged": statusChanged,
 	})
 }
 
@@ -213,16 +192,14 @@ func DeleteTrip(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, _ := strconv.Atoi(idStr)
 
-	_, err := dbPool.Exec(context.Background(), `DELETE FROM trips WHERE id=$1`, id)
-	if err != nil {
+	if err := repo.Trips().Delete(r.Context(), id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 
-	broadcastToSSE(map[string]interface{}{
-		"type":   "trip_deleted",
+	events.Publish("trip_deleted", map[string]interface{}{
 		"tripId": id,
 	})
 }
@@ -233,41 +210,126 @@ func UpdateTripLocation(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, _ := strconv.Atoi(idStr)
 
-	var body struct {
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-	}
+	var body store.Position
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	_, err := dbPool.Exec(context.Background(),
-		`UPDATE trips SET latitude=$1, longitude=$2, last_updated=NOW() WHERE id=$3`,
-		body.Latitude, body.Longitude, id,
-	)
+	t, err := tracking.UpdateTripLocation(r.Context(), id, 0, body)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Trip not found or already finished", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, tracking.ErrInvalidFix) {
+		http.Error(w, "Coordinates out of range", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(t)
+}
+
+// Long tracks are simplified by default so the dashboard map stays responsive
+var (
+	TrackMaxPoints        = 2000
+	TrackDefaultTolerance = 10.0 // metres
+)
+
+// timeParam parses an optional RFC 3339 query parameter
+func timeParam(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTripTrack returns the route a trip actually took, as ordered points and
+// a GeoJSON LineString. Optional query parameters:
+//
+//	from, to   RFC 3339 timestamps bounding the fixes
+//	tolerance  simplification tolerance in metres (0 disables)
+func GetTripTrack(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	from, err := timeParam(r, "from")
+	if err != nil {
+		http.Error(w, "Invalid from time, use RFC 3339", http.StatusBadRequest)
+		return
+	}
+	to, err := timeParam(r, "to")
+	if err != nil {
+		http.Error(w, "Invalid to time, use RFC 3339", http.StatusBadRequest)
+		return
+	}
+
+	tolerance := -1.0
+	if v := r.URL.Query().Get("tolerance"); v != "" {
+		tolerance, err = strconv.ParseFloat(v, 64)
+		if err != nil || tolerance < 0 {
+			http.Error(w, "Invalid tolerance", http.StatusBadRequest)
+			return
+		}
+	}
+
+	exists, err := repo.Trips().Exists(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !exists {
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	}
 
-	var t Trips
-	err = dbPool.QueryRow(context.Background(),
-		`SELECT id, dispatch_id, driver_id, vehicle_id, status, latitude, longitude, last_updated 
-		 FROM trips WHERE id=$1 AND status != 'completed'`, id,
-	).Scan(&t.ID, &t.DispatchID, &t.Driver.IDNumber, &t.Vehicle.ID,
-		&t.Status, &t.Latitude, &t.Longitude, &t.LastUpdated)
+	points, err := repo.Positions().ListByTrip(r.Context(), id, from, to)
 	if err != nil {
-		http.Error(w, "Trip not found or already completed", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	broadcastToSSE(map[string]interface{}{
-		"type": "location_update",
-		"trip": t,
-	})
+	total := len(points)
+	if tolerance < 0 && total > TrackMaxPoints {
+		tolerance = TrackDefaultTolerance
+	}
+	points = tracking.Simplify(points, tolerance)
+	if points == nil {
+		points = []store.Position{}
+	}
 
-	json.NewEncoder(w).Encode(t)
+	props := map[string]interface{}{
+		"tripId":         id,
+		"pointCount":     len(points),
+		"originalPoints": total,
+		"simplified":     len(points) < total,
+	}
+	if len(points) > 0 {
+		props["start"] = points[0].RecordedAt
+		props["end"] = points[len(points)-1].RecordedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tripId": id,
+		"points": points,
+		"geojson": tracking.Feature{
+			Type:       "Feature",
+			Geometry:   tracking.ToLineString(points),
+			Properties: props,
+		},
+	})
 }
 
 // ---------------- New Endpoints ----------------
@@ -281,62 +343,93 @@ func GetTripsByDispatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := dbPool.Query(context.Background(),
-		`SELECT id, dispatch_id, driver_id, vehicle_id, status, latitude, longitude, last_updated
-		 FROM trips WHERE dispatch_id=$1`, dispatchID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var res []Trips
-	for rows.Next() {
-		var t Trips
-		if err := rows.Scan(&t.ID, &t.DispatchID, &t.Driver.IDNumber, &t.Vehicle.ID,
-			&t.Status, &t.Latitude, &t.Longitude, &t.LastUpdated); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		res = append(res, t)
-	}
-
-	json.NewEncoder(w).Encode(res)
+	writeTrips(w, r, store.TripFilter{DispatchID: dispatchID})
 }
 
-// CompleteTrip marks a trip as completed
+// CompleteTrip marks a trip as delivered on an admin's say-so. The dispatch
+// becomes delivered but not verified: verified is kept for the recipient's
+// own confirmation by OTP, and the history records which admin completed it.
 func CompleteTrip(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, _ := strconv.Atoi(idStr)
 
-	_, err := dbPool.Exec(context.Background(),
-		`UPDATE trips SET status='completed', last_updated=NOW() WHERE id=$1`, id)
+	err := repo.InTx(r.Context(), func(tx store.Repo) error {
+		// Wait out a reassign or OTP check of the dispatch before finishing
+		current, err := tx.Trips().Get(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if current.DispatchID != 0 {
+			if err := tx.Dispatches().Lock(r.Context(), current.DispatchID); err != nil {
+				return err
+			}
+		}
+
+		t, err := tx.Trips().Transition(r.Context(), id, store.TripDelivered, auth.Actor(r.Context()), "")
+		if err != nil {
+			return err
+		}
+		return settleDispatch(r.Context(), tx, t)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeTripError(w, err)
 		return
 	}
 
-	broadcastToSSE(map[string]interface{}{
-		"type":   "trip_completed",
+	events.Publish("trip_completed", map[string]interface{}{
 		"tripId": id,
+		"status": store.TripDelivered,
 	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetTripHistory lists a trip's status changes with who made them
+func GetTripHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := repo.Trips().Exists(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := repo.Trips().History(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
 // RegisterTripRoutes registers all trip endpoints
 func RegisterTripRoutes(r *mux.Router) {
 	r.HandleFunc("/trips", GetTrips).Methods("GET")
 	r.HandleFunc("/trips/{id}", GetTrip).Methods("GET")
-	r.HandleFunc("/trips/{id}", UpdateTrip).Methods("PUT")
-	r.HandleFunc("/trips/{id}", DeleteTrip).Methods("DELETE")
-	r.HandleFunc("/trips/{id}/complete", CompleteTrip).Methods("PUT")
+	r.HandleFunc("/trips/{id}", auth.RequirePermission(UpdateTrip, auth.PermDispatch)).Methods("PUT")
+	r.HandleFunc("/trips/{id}", auth.RequirePermission(DeleteTrip, auth.PermDispatch)).Methods("DELETE")
+	r.HandleFunc("/trips/{id}/complete", auth.RequirePermission(CompleteTrip, auth.PermDispatch)).Methods("PUT")
+	r.HandleFunc("/trips/{id}/history", GetTripHistory).Methods("GET")
 	r.HandleFunc("/drivers/{driverId}/trips", GetTripsByDriver).Methods("GET")
 
 	// Fetch trips by dispatch
 	r.HandleFunc("/dispatches/{dispatchId}/trips", GetTripsByDispatch).Methods("GET")
 
 	// Live tracking
-	r.HandleFunc("/trips/{id}/location", UpdateTripLocation).Methods("PUT")
+	r.HandleFunc("/trips/{id}/location", auth.RequirePermission(UpdateTripLocation, auth.PermDispatch)).Methods("PUT")
+	r.HandleFunc("/trips/{id}/track", GetTripTrack).Methods("GET")
+
+	// Dashboard event stream (trip_created, trip_updated, location_update, trip_completed, ...)
+	r.HandleFunc("/events", events.Handler).Methods("GET")
 
 }
@@ -0,0 +1,96 @@
+package Admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+	"github.com/mangochops/coninx_backend/tracking"
+)
+
+func TestUpdateTripChecksBeforeMoving(t *testing.T) {
+	tests := []struct {
+		name       string
+		delivered  bool // trip finished before the update
+		body       string
+		wantCode   int
+		wantStatus string
+		wantMoved  bool
+	}{
+		{name: "move and arrive", body: `{"latitude":-1.3,"longitude":36.8,"status":"arrived"}`,
+			wantCode: http.StatusOK, wantStatus: store.TripArrived, wantMoved: true},
+		{name: "move with an illegal status", body: `{"latitude":-1.3,"longitude":36.8,"status":"accepted"}`,
+			wantCode: http.StatusConflict, wantStatus: store.TripEnRoute},
+		{name: "move with an unknown status", body: `{"latitude":-1.3,"longitude":36.8,"status":"lost"}`,
+			wantCode: http.StatusBadRequest, wantStatus: store.TripEnRoute},
+		{name: "move a finished trip", delivered: true, body: `{"latitude":-1.3,"longitude":36.8}`,
+			wantCode: http.StatusConflict, wantStatus: store.TripDelivered},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := storetest.New()
+			InitStore(s)
+			tracking.Init(s)
+			_, trip := seedEnRoute(t, s)
+			if tt.delivered {
+				if _, err := s.Trips().Transition(ctx, trip.ID, store.TripDelivered, "test", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(trip.ID)})
+			w := httptest.NewRecorder()
+			UpdateTrip(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+
+			after, err := s.Trips().Get(ctx, trip.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Status != tt.wantStatus {
+				t.Errorf("trip status = %q, want %q", after.Status, tt.wantStatus)
+			}
+			track, err := s.Positions().ListByTrip(ctx, trip.ID, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved := len(track) > 0; moved != tt.wantMoved {
+				t.Errorf("moved = %v, want %v", moved, tt.wantMoved)
+			}
+		})
+	}
+}
+
+func TestCompleteTripLeavesDispatchUnverified(t *testing.T) {
+	ctx := context.Background()
+	s := storetest.New()
+	InitStore(s)
+	ds, trip := seedEnRoute(t, s)
+
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(trip.ID)})
+	w := httptest.NewRecorder()
+	CompleteTrip(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got %d %q, want 204", w.Code, w.Body.String())
+	}
+
+	after, err := s.Dispatches().Get(ctx, ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Status != store.DispatchDelivered || after.Verified {
+		t.Errorf("dispatch = %s/verified=%v, want delivered and unverified", after.Status, after.Verified)
+	}
+}
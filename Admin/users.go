@@ -0,0 +1,102 @@
+package Admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// AdminUser is an admin_users row without the password
+type AdminUser = store.AdminUser
+
+// ListAdmins returns every admin account
+func ListAdmins(w http.ResponseWriter, r *http.Request) {
+	admins, err := repo.AdminUsers().List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admins)
+}
+
+// UpdateAdminRole changes an admin's role. The last owner can't be demoted.
+func UpdateAdminRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !auth.ValidAdminRole(body.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Refuse if this would leave no owners
+	a, err := repo.AdminUsers().SetRole(ctx, id, body.Role)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Admin not found or is the last owner", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[Admins] %s role set to %s\n", a.Email, a.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// DeleteAdmin removes an admin account and ends its sessions. The last owner can't be removed.
+func DeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	err = repo.AdminUsers().Delete(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Admin not found or is the last owner", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := auth.RevokeAllSessions(ctx, auth.RoleAdmin, id); err != nil {
+		log.Printf("[Admins] Failed to revoke sessions for admin %d: %v\n", id, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterAdminUserRoutes registers owner-only admin management endpoints
+func RegisterAdminUserRoutes(r *mux.Router) {
+	r.HandleFunc("/users", auth.RequirePermission(ListAdmins, auth.PermManageAdmins)).Methods("GET")
+	r.HandleFunc("/invitations", auth.RequirePermission(InviteHandler, auth.PermManageAdmins)).Methods("POST")
+	r.HandleFunc("/users/{id}/role", auth.RequirePermission(UpdateAdminRole, auth.PermManageAdmins)).Methods("PUT")
+	r.HandleFunc("/users/{id}", auth.RequirePermission(DeleteAdmin, auth.PermManageAdmins)).Methods("DELETE")
+}
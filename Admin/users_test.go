@@ -0,0 +1,89 @@
+package Admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+// seedAdmins creates one admin per role, in order, and returns their ids
+func seedAdmins(t *testing.T, s *storetest.Store, roles ...string) []int {
+	t.Helper()
+	var ids []int
+	for i, role := range roles {
+		a := store.AdminUser{Email: "admin" + strconv.Itoa(i) + "@example.com", Role: role}
+		if err := s.AdminUsers().Create(context.Background(), &a, "hash"); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestUpdateAdminRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		admins   []string
+		target   int // index into admins
+		role     string
+		wantCode int
+		wantRole string
+	}{
+		{"demote the only owner", []string{auth.AdminOwner, auth.AdminViewer}, 0, auth.AdminViewer, http.StatusConflict, auth.AdminOwner},
+		{"demote one of two owners", []string{auth.AdminOwner, auth.AdminOwner}, 0, auth.AdminDispatcher, http.StatusOK, auth.AdminDispatcher},
+		{"promote a viewer", []string{auth.AdminOwner, auth.AdminViewer}, 1, auth.AdminOwner, http.StatusOK, auth.AdminOwner},
+		{"unknown role", []string{auth.AdminOwner, auth.AdminViewer}, 1, "superuser", http.StatusBadRequest, auth.AdminViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storetest.New()
+			InitStore(s)
+			ids := seedAdmins(t, s, tt.admins...)
+
+			r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"role":"`+tt.role+`"}`))
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(ids[tt.target])})
+			w := httptest.NewRecorder()
+			UpdateAdminRole(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			_, role, _, err := s.AdminUsers().Credentials(context.Background(), "admin"+strconv.Itoa(tt.target)+"@example.com")
+			if err != nil || role != tt.wantRole {
+				t.Errorf("stored role = %q (%v), want %q", role, err, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestDeleteAdminKeepsLastOwner(t *testing.T) {
+	s := storetest.New()
+	InitStore(s)
+	ids := seedAdmins(t, s, auth.AdminOwner, auth.AdminViewer)
+
+	for _, tt := range []struct {
+		id       int
+		wantCode int
+	}{
+		{ids[0], http.StatusConflict},
+		{ids[1], http.StatusNoContent},
+		{ids[1], http.StatusConflict}, // already gone
+	} {
+		r := httptest.NewRequest(http.MethodDelete, "/", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(tt.id)})
+		w := httptest.NewRecorder()
+		DeleteAdmin(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("delete %d: got %d %q, want %d", tt.id, w.Code, w.Body.String(), tt.wantCode)
+		}
+	}
+}
@@ -1,21 +1,18 @@
 package Admin
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store"
 )
 
 // Vehicle struct
-type Vehicle struct {
-	ID     int    `json:"id"`
-	Type   string `json:"type"`
-	RegNo  string `json:"reg_no"`
-	Status bool   `json:"status"`
-}
+type Vehicle = store.Vehicle
 
 // CreateVehicle inserts a new vehicle
 func CreateVehicle(w http.ResponseWriter, r *http.Request) {
@@ -25,13 +22,7 @@ func CreateVehicle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use db from auth.go
-	err := dbPool.QueryRow(
-		context.Background(),
-		`INSERT INTO vehicles (type, reg_no, status) VALUES ($1, $2, $3) RETURNING id`,
-		v.Type, v.RegNo, v.Status,
-	).Scan(&v.ID)
-	if err != nil {
+	if err := repo.Vehicles().Create(r.Context(), &v); err != nil {
 		http.Error(w, "Failed to insert vehicle: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -42,22 +33,11 @@ func CreateVehicle(w http.ResponseWriter, r *http.Request) {
 
 // GetVehicles returns all vehicles
 func GetVehicles(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbPool.Query(context.Background(), `SELECT id, type, reg_no, status FROM vehicles`)
+	vehicles, err := repo.Vehicles().List(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to fetch vehicles: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var vehicles []Vehicle
-	for rows.Next() {
-		var v Vehicle
-		if err := rows.Scan(&v.ID, &v.Type, &v.RegNo, &v.Status); err != nil {
-			http.Error(w, "Error scanning vehicle: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		vehicles = append(vehicles, v)
-	}
 
 	json.NewEncoder(w).Encode(vehicles)
 }
@@ -71,15 +51,9 @@ func GetVehicle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var v Vehicle
-	err = dbPool.QueryRow(context.Background(),
-		`SELECT id, type, reg_no, status FROM vehicles WHERE id = $1`,
-		id,
-	).Scan(&v.ID, &v.Type, &v.RegNo, &v.Status)
-
+	v, err := repo.Vehicles().Get(r.Context(), id)
 	if err != nil {
-		// Handle "no rows" without pgx.ErrNoRows directly
-		if err.Error() == "no rows in result set" {
+		if errors.Is(err, store.ErrNotFound) {
 			http.NotFound(w, r)
 		} else {
 			http.Error(w, "Failed to fetch vehicle: "+err.Error(), http.StatusInternalServerError)
@@ -105,16 +79,12 @@ func UpdateVehicle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = dbPool.Exec(context.Background(),
-		`UPDATE vehicles SET type=$1, reg_no=$2, status=$3 WHERE id=$4`,
-		v.Type, v.RegNo, v.Status, id,
-	)
-	if err != nil {
+	v.ID = id
+	if err := repo.Vehicles().Update(r.Context(), &v); err != nil {
 		http.Error(w, "Failed to update vehicle: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	v.ID = id
 	json.NewEncoder(w).Encode(v)
 }
 
@@ -127,8 +97,7 @@ func DeleteVehicle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = dbPool.Exec(context.Background(), `DELETE FROM vehicles WHERE id=$1`, id)
-	if err != nil {
+	if err := repo.Vehicles().Delete(r.Context(), id); err != nil {
 		http.Error(w, "Failed to delete vehicle: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -138,10 +107,10 @@ func DeleteVehicle(w http.ResponseWriter, r *http.Request) {
 
 // RegisterVehicleRoutes registers vehicle endpoints
 func RegisterVehicleRoutes(r *mux.Router) {
-	r.HandleFunc("/vehicles", CreateVehicle).Methods("POST")
+	r.HandleFunc("/vehicles", auth.RequirePermission(CreateVehicle, auth.PermManageVehicles)).Methods("POST")
 	r.HandleFunc("/vehicles", GetVehicles).Methods("GET")
 	r.HandleFunc("/vehicles/{id}", GetVehicle).Methods("GET")
-	r.HandleFunc("/vehicles/{id}", UpdateVehicle).Methods("PUT")
-	r.HandleFunc("/vehicles/{id}", DeleteVehicle).Methods("DELETE")
+	r.HandleFunc("/vehicles/{id}", auth.RequirePermission(UpdateVehicle, auth.PermManageVehicles)).Methods("PUT")
+	r.HandleFunc("/vehicles/{id}", auth.RequirePermission(DeleteVehicle, auth.PermDeleteVehicles)).Methods("DELETE")
 }
 
@@ -0,0 +1,191 @@
+package Driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
+)
+
+// MaxRejectReasonLength bounds the free-text reason for a rejected trip
+var MaxRejectReasonLength = 500
+
+// errRejectTooLate is returned when a driver rejects a trip they've already started
+var errRejectTooLate = errors.New("trip already started; report a failed or partial delivery instead")
+
+// errFinishNeedsOTP is returned when a driver finishes a trip the recipient hasn't confirmed
+var errFinishNeedsOTP = errors.New("trip can only be finished once it has arrived and the recipient's OTP is verified")
+
+// ListDriverTripsHandler lists the driver's unfinished trips. ?status=
+// narrows to one status, e.g. assigned for trips awaiting a response.
+func ListDriverTripsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	f := store.TripFilter{DriverID: id, ActiveOnly: true}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if !store.ValidTripStatus(status) {
+			http.Error(w, "Unknown trip status", http.StatusBadRequest)
+			return
+		}
+		f.Status = status
+		f.ActiveOnly = false
+	}
+
+	trips, err := repo.Trips().List(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if trips == nil {
+		trips = []store.Trip{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trips)
+}
+
+// transitionOwnTrip moves one of the driver's trips to status
+func transitionOwnTrip(ctx context.Context, driverID, tripID int, status, reason string) (*store.Trip, error) {
+	var t *store.Trip
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		current, err := tx.Trips().Get(ctx, tripID)
+		if err != nil {
+			return err
+		}
+		// Wait out any reassign of the dispatch, then look again
+		if current.DispatchID != 0 {
+			if err := tx.Dispatches().Lock(ctx, current.DispatchID); err != nil {
+				return err
+			}
+			if current, err = tx.Trips().Get(ctx, tripID); err != nil {
+				return err
+			}
+		}
+		if current.Driver.ID != driverID {
+			return tracking.ErrNotOwner
+		}
+		// Drivers may only turn down trips they haven't set off on
+		if status == store.TripCancelled && current.Status != store.TripAssigned && current.Status != store.TripAccepted {
+			return errRejectTooLate
+		}
+		// Drivers finish only what the recipient has confirmed by OTP
+		if status == store.TripDelivered {
+			if current.Status != store.TripArrived || current.DispatchID == 0 {
+				return errFinishNeedsOTP
+			}
+			ds, err := tx.Dispatches().Get(ctx, current.DispatchID)
+			if err != nil {
+				return err
+			}
+			if !ds.Verified {
+				return errFinishNeedsOTP
+			}
+		}
+
+		t, err = tx.Trips().Transition(ctx, tripID, status, auth.Actor(ctx), reason)
+		if err != nil || status != store.TripDelivered {
+			return err
+		}
+		return tx.Dispatches().SetStatus(ctx, t.DispatchID, store.DispatchDelivered)
+	})
+	return t, err
+}
+
+// tripAction handles accept/reject/start/finish for the driver in {id} and trip in {tripId}
+func tripAction(w http.ResponseWriter, r *http.Request, status, reason, eventType string) {
+	driverID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+	tripID, err := strconv.Atoi(mux.Vars(r)["tripId"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID", http.StatusBadRequest)
+		return
+	}
+
+	t, err := transitionOwnTrip(r.Context(), driverID, tripID, status, reason)
+	var te *store.TransitionError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	case errors.Is(err, tracking.ErrNotOwner):
+		http.Error(w, "Trip is not assigned to this driver", http.StatusForbidden)
+		return
+	case errors.As(err, &te), errors.Is(err, errRejectTooLate), errors.Is(err, errFinishNeedsOTP):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+
+	payload := map[string]interface{}{
+		"trip":       t,
+		"tripId":     t.ID,
+		"dispatchId": t.DispatchID,
+		"driverId":   driverID,
+		"status":     t.Status,
+	}
+	if reason != "" {
+		payload["reason"] = reason
+	}
+	events.Publish(eventType, payload)
+}
+
+// AcceptTripHandler confirms the driver will take an assigned trip
+func AcceptTripHandler(w http.ResponseWriter, r *http.Request) {
+	tripAction(w, r, store.TripAccepted, "", "trip_accepted")
+}
+
+// RejectTripHandler declines an assigned or accepted trip. The trip is
+// cancelled and the dashboard gets a trip_rejected event so the dispatch
+// can be given to someone else. Trips under way end through the fail and
+// partial outcomes instead.
+func RejectTripHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.Reason) > MaxRejectReasonLength {
+		http.Error(w, "Reason too long (max "+strconv.Itoa(MaxRejectReasonLength)+" characters)", http.StatusBadRequest)
+		return
+	}
+
+	tripAction(w, r, store.TripCancelled, body.Reason, "trip_rejected")
+}
+
+// StartTripHandler marks an accepted trip as en route
+func StartTripHandler(w http.ResponseWriter, r *http.Request) {
+	tripAction(w, r, store.TripEnRoute, "", "trip_started")
+}
+
+// FinishTripHandler marks an arrived trip delivered once the recipient's OTP
+// is verified. Until then the driver hands over through the OTP flow.
+func FinishTripHandler(w http.ResponseWriter, r *http.Request) {
+	tripAction(w, r, store.TripDelivered, "", "trip_completed")
+}
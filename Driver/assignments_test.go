@@ -0,0 +1,151 @@
+package Driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+// seedTrip creates a driver, a dispatch and a trip moved along to status,
+// and returns the driver's id and the trip
+func seedTrip(t *testing.T, s *storetest.Store, status string) (int, *store.Trip) {
+	t.Helper()
+	ctx := context.Background()
+
+	d := store.Driver{FirstName: "Jane", LastName: "Doe", IDNumber: 12345678}
+	if err := s.Drivers().Create(ctx, &d, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	v := store.Vehicle{Type: "van", RegNo: "KAA 123A"}
+	if err := s.Vehicles().Create(ctx, &v); err != nil {
+		t.Fatal(err)
+	}
+	ds := store.Dispatch{Recipient: "Acme", Location: "Westlands"}
+	if err := s.Dispatches().Create(ctx, &ds, d.ID, v.ID); err != nil {
+		t.Fatal(err)
+	}
+	trip, err := s.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, next := range []string{store.TripAccepted, store.TripEnRoute, store.TripArrived} {
+		if trip.Status == status {
+			break
+		}
+		if trip, err = s.Trips().Transition(ctx, trip.ID, next, "test", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d.ID, trip
+}
+
+// callTripAction runs h for driverID and tripID with body
+func callTripAction(h http.HandlerFunc, driverID, tripID int, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(driverID), "tripId": strconv.Itoa(tripID)})
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestTripActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		from        string
+		handler     http.HandlerFunc
+		body        string
+		otherDriver bool
+		verified    bool // recipient's OTP already checked
+		wantCode    int
+		wantStatus  string // trip status afterwards
+	}{
+		{name: "accept assigned", from: store.TripAssigned, handler: AcceptTripHandler,
+			wantCode: http.StatusOK, wantStatus: store.TripAccepted},
+		{name: "start accepted", from: store.TripAccepted, handler: StartTripHandler,
+			wantCode: http.StatusOK, wantStatus: store.TripEnRoute},
+		{name: "start assigned", from: store.TripAssigned, handler: StartTripHandler,
+			wantCode: http.StatusConflict, wantStatus: store.TripAssigned},
+		{name: "reject assigned", from: store.TripAssigned, handler: RejectTripHandler, body: `{"reason":"van broke down"}`,
+			wantCode: http.StatusOK, wantStatus: store.TripCancelled},
+		{name: "reject accepted", from: store.TripAccepted, handler: RejectTripHandler, body: `{"reason":"sick"}`,
+			wantCode: http.StatusOK, wantStatus: store.TripCancelled},
+		{name: "reject en route", from: store.TripEnRoute, handler: RejectTripHandler, body: `{"reason":"late"}`,
+			wantCode: http.StatusConflict, wantStatus: store.TripEnRoute},
+		{name: "reject arrived", from: store.TripArrived, handler: RejectTripHandler, body: `{"reason":"late"}`,
+			wantCode: http.StatusConflict, wantStatus: store.TripArrived},
+		{name: "reject without reason", from: store.TripAssigned, handler: RejectTripHandler, body: `{"reason":"  "}`,
+			wantCode: http.StatusBadRequest, wantStatus: store.TripAssigned},
+		{name: "finish arrived without OTP", from: store.TripArrived, handler: FinishTripHandler,
+			wantCode: http.StatusConflict, wantStatus: store.TripArrived},
+		{name: "finish arrived with OTP verified", from: store.TripArrived, handler: FinishTripHandler, verified: true,
+			wantCode: http.StatusOK, wantStatus: store.TripDelivered},
+		{name: "finish en route with OTP verified", from: store.TripEnRoute, handler: FinishTripHandler, verified: true,
+			wantCode: http.StatusConflict, wantStatus: store.TripEnRoute},
+		{name: "accept another driver's trip", from: store.TripAssigned, handler: AcceptTripHandler, otherDriver: true,
+			wantCode: http.StatusForbidden, wantStatus: store.TripAssigned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storetest.New()
+			InitStore(s)
+
+			driverID, trip := seedTrip(t, s, tt.from)
+			if tt.verified {
+				if err := s.Dispatches().MarkVerified(context.Background(), trip.DispatchID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.otherDriver {
+				driverID++
+			}
+
+			w := callTripAction(tt.handler, driverID, trip.ID, tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			if w.Code == http.StatusOK {
+				var got store.Trip
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.Status != tt.wantStatus {
+					t.Errorf("response trip status = %q (%v), want %q", got.Status, err, tt.wantStatus)
+				}
+			}
+
+			after, err := s.Trips().Get(context.Background(), trip.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Status != tt.wantStatus {
+				t.Errorf("stored trip status = %q, want %q", after.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRejectRecordsReason(t *testing.T) {
+	s := storetest.New()
+	InitStore(s)
+	driverID, trip := seedTrip(t, s, store.TripAssigned)
+
+	if w := callTripAction(RejectTripHandler, driverID, trip.ID, `{"reason":"van broke down"}`); w.Code != http.StatusOK {
+		t.Fatalf("got %d %q, want 200", w.Code, w.Body.String())
+	}
+
+	history, err := s.Trips().History(context.Background(), trip.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.To != store.TripCancelled || last.Reason == nil || *last.Reason != "van broke down" {
+		t.Errorf("last change = %+v, want cancelled with the reason", last)
+	}
+}
@@ -1,31 +1,34 @@
 package Driver
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/idempotency"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
+	"golang.org/x/crypto/bcrypt"
 )
 
-type Driver struct {
-	ID          int    `json:"id"` // ✅ new primary key field
-	FirstName   string `json:"firstName"`
-	LastName    string `json:"lastName"`
-	IDNumber    int    `json:"idNumber"`
-	Password    string `json:"password"`
-	PhoneNumber *int64 `json:"phoneNumber,omitempty"`
-}
+type Driver = store.Driver
 
-var db *pgxpool.Pool
+var repo store.Repo
 
-// InitDB sets the global DB connection
-func InitDB(pool *pgxpool.Pool) {
-	db = pool
+// InitStore sets the shared store used by the Driver package
+func InitStore(s store.Repo) {
+	repo = s
 }
 
 // ========================= REGISTER DRIVER ===========================
+// RegisterHandler creates a driver account. Admin-only; see RegisterAdminRoutes.
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
@@ -38,16 +41,18 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if db == nil {
+	if repo == nil {
 		http.Error(w, "Database not initialized", http.StatusInternalServerError)
 		return
 	}
 
-	_, err := db.Exec(r.Context(),
-		"INSERT INTO drivers (first_name, last_name, id_number, password, phone_number) VALUES ($1, $2, $3, $4, $5)",
-		d.FirstName, d.LastName, d.IDNumber, d.Password, d.PhoneNumber,
-	)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(d.Password), bcrypt.DefaultCost)
 	if err != nil {
+		http.Error(w, "Error securing password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := repo.Drivers().Create(r.Context(), &d, string(hashedPassword)); err != nil {
 		http.Error(w, "Failed to register driver: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -58,35 +63,23 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
 // ========================= FETCH ALL DRIVERS ===========================
 func GetDriversHandler(w http.ResponseWriter, r *http.Request) {
-	if db == nil {
+	if repo == nil {
 		http.Error(w, "Database not initialized", http.StatusInternalServerError)
 		return
 	}
 
-	rows, err := db.Query(r.Context(),
-		"SELECT id, id_number, first_name, last_name, phone_number FROM drivers")
+	drivers, err := repo.Drivers().List(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to fetch drivers: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var drivers []Driver
-	for rows.Next() {
-		var d Driver
-		if err := rows.Scan(&d.ID, &d.IDNumber, &d.FirstName, &d.LastName, &d.PhoneNumber); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		drivers = append(drivers, d)
-	}
 
 	json.NewEncoder(w).Encode(drivers)
 }
 
 // ========================= FETCH DRIVER BY ID ===========================
 func GetDriverByIDHandler(w http.ResponseWriter, r *http.Request) {
-	if db == nil {
+	if repo == nil {
 		http.Error(w, "Database not initialized", http.StatusInternalServerError)
 		return
 	}
@@ -99,11 +92,7 @@ func GetDriverByIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var d Driver
-	err = db.QueryRow(r.Context(),
-		"SELECT id, first_name, last_name, id_number, phone_number FROM drivers WHERE id=$1", id,
-	).Scan(&d.ID, &d.FirstName, &d.LastName, &d.IDNumber, &d.PhoneNumber)
-
+	d, err := repo.Drivers().Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Driver not found", http.StatusNotFound)
 		return
@@ -135,34 +124,150 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var dbID int
-	var storedPassword string
+	// Key on the parsed number so "0123" and "123" share one counter
+	accountKey := auth.AccountKey(auth.RoleDriver, strconv.Itoa(idNum))
+	ipKey := auth.IPKey(r)
 
-	err = db.QueryRow(r.Context(),
-		"SELECT id, password FROM drivers WHERE id_number=$1", idNum,
-	).Scan(&dbID, &storedPassword)
+	wait, err := auth.CheckLockout(r.Context(), accountKey, ipKey)
 	if err != nil {
-		http.Error(w, "Driver not found", http.StatusNotFound)
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
 		return
 	}
 
-	if storedPassword != creds.Password {
-		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+	dbID, storedPassword, err := repo.Drivers().Credentials(r.Context(), idNum)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Failed to look up driver", http.StatusInternalServerError)
+		return
+	}
+
+	// Unknown ID numbers and wrong passwords get the same response, timing and
+	// lockout treatment so national ID numbers can't be enumerated
+	found := err == nil
+	if !found {
+		auth.CompareDummyPassword(creds.Password)
+	}
+	if !found || !checkPassword(storedPassword, creds.Password) {
+		if err := auth.RecordLoginFailure(r.Context(), accountKey, ipKey); err != nil {
+			log.Println("[Driver Login] Failed to record attempt:", err)
+		}
+		http.Error(w, "Invalid ID number or password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := auth.RecordLoginSuccess(r.Context(), accountKey); err != nil {
+		log.Println("[Driver Login] Failed to clear attempts:", err)
+	}
+
+	// Legacy rows hold the password verbatim; upgrade them now that we know it
+	if !isBcryptHash(storedPassword) {
+		if err := rehashPassword(r.Context(), dbID, creds.Password); err != nil {
+			log.Printf("[Driver Login] Failed to rehash password for driver %d: %v\n", dbID, err)
+		}
+	}
+
+	tokens, err := auth.CreateSession(r.Context(), auth.RoleDriver, dbID)
+	if err != nil {
+		http.Error(w, "Could not create session", http.StatusInternalServerError)
 		return
 	}
 
 	resp := map[string]interface{}{
-		"id":      dbID,
-		"message": "Login successful",
+		"id":               dbID,
+		"message":          "Login successful",
+		"accessToken":      tokens.AccessToken,
+		"refreshToken":     tokens.RefreshToken,
+		"tokenType":        tokens.TokenType,
+		"expiresAt":        tokens.ExpiresAt,
+		"refreshExpiresAt": tokens.RefreshExpiresAt,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ========================= PASSWORDS ===========================
+
+// isBcryptHash reports whether a stored password is already a bcrypt hash
+func isBcryptHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword compares against a bcrypt hash, or a legacy plaintext row
+func checkPassword(stored, given string) bool {
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(given)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(given)) == 1
+}
+
+// rehashPassword replaces a plaintext password with its bcrypt hash
+func rehashPassword(ctx context.Context, driverID int, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return repo.Drivers().UpgradePassword(ctx, driverID, string(hashed))
+}
+
+// CountPlaintextPasswords returns how many drivers still have an unhashed password
+func CountPlaintextPasswords(ctx context.Context) (int, error) {
+	if repo == nil {
+		return 0, errors.New("database not initialized")
+	}
+
+	return repo.Drivers().CountPlaintextPasswords(ctx)
+}
+
+// ========================= REFRESH ===========================
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := auth.RefreshSession(r.Context(), auth.RoleDriver, body.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// ========================= LOGOUT ===========================
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := auth.RevokeSession(r.Context(), claims.SessionID); err != nil {
+		http.Error(w, "Logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ========================= UPDATE DRIVER LOCATION ===========================
 func UpdateDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
-	if db == nil {
+	if repo == nil {
 		http.Error(w, "Database not initialized", http.StatusInternalServerError)
 		return
 	}
@@ -175,21 +280,17 @@ func UpdateDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var loc struct {
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&loc); err != nil {
+	var pos store.Position
+	if err := json.NewDecoder(r.Body).Decode(&pos); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec(r.Context(),
-		"UPDATE drivers SET latitude=$1, longitude=$2 WHERE id=$3",
-		loc.Latitude, loc.Longitude, id,
-	)
-	if err != nil {
+	if err := tracking.UpdateDriverLocation(r.Context(), id, pos); err != nil {
+		if errors.Is(err, tracking.ErrInvalidFix) {
+			http.Error(w, "Coordinates out of range", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to update location: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -198,9 +299,56 @@ func UpdateDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Location updated successfully"))
 }
 
+// ========================= BATCH LOCATION UPLOAD ===========================
+
+// UploadLocationBatchHandler accepts fixes the app buffered while offline:
+//
+//	{"tripId": 12, "fixes": [{"latitude": .., "longitude": .., "recordedAt": "..."}, ...]}
+//
+// tripId is optional and defaults to the driver's active trip.
+func UploadLocationBatchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		TripID int              `json:"tripId"`
+		Fixes  []store.Position `json:"fixes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if len(body.Fixes) > tracking.MaxBatchFixes {
+		http.Error(w, "Too many fixes in one batch (max "+strconv.Itoa(tracking.MaxBatchFixes)+")", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res, err := tracking.RecordBatch(r.Context(), id, body.TripID, body.Fixes)
+	switch {
+	case errors.Is(err, tracking.ErrInvalidFix):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	case errors.Is(err, tracking.ErrNotOwner):
+		http.Error(w, "Trip is not assigned to this driver", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Failed to store locations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 // ========================= GET DRIVER LOCATION ===========================
 func GetDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
-	if db == nil {
+	if repo == nil {
 		http.Error(w, "Database not initialized", http.StatusInternalServerError)
 		return
 	}
@@ -213,14 +361,7 @@ func GetDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var loc struct {
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-	}
-	err = db.QueryRow(r.Context(),
-		"SELECT latitude, longitude FROM drivers WHERE id=$1", id,
-	).Scan(&loc.Latitude, &loc.Longitude)
-
+	loc, err := repo.Drivers().GetLocation(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Driver location not found", http.StatusNotFound)
 		return
@@ -230,11 +371,25 @@ func GetDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // ========================= ROUTE REGISTRATION ===========================
-func RegisterDriverRoutes(r *mux.Router) {
-	r.HandleFunc("/register", RegisterHandler).Methods("POST")
+
+// RegisterAuthRoutes registers the public (unauthenticated) driver endpoints
+func RegisterAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/login", LoginHandler).Methods("POST")
-	r.HandleFunc("/all", GetDriversHandler).Methods("GET")
-	r.HandleFunc("/{id}", GetDriverByIDHandler).Methods("GET")
-	r.HandleFunc("/{id}/location", UpdateDriverLocationHandler).Methods("POST")
-	r.HandleFunc("/{id}/location", GetDriverLocationHandler).Methods("GET")
+	r.HandleFunc("/refresh", RefreshHandler).Methods("POST")
+}
+
+// RegisterAdminRoutes adds driver management endpoints to the admin router
+func RegisterAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/drivers", auth.RequirePermission(RegisterHandler, auth.PermManageDrivers)).Methods("POST")
+}
+
+// RegisterDriverRoutes registers driver endpoints behind the auth middleware.
+// Driver tokens may only touch their own /{id} routes.
+func RegisterDriverRoutes(r *mux.Router) {
+	r.HandleFunc("/logout", LogoutHandler).Methods("POST")
+	r.HandleFunc("/all", auth.RequireRole(GetDriversHandler, auth.RoleAdmin)).Methods("GET")
+	r.HandleFunc("/{id}", auth.RequireDriverSelf(GetDriverByIDHandler, "id")).Methods("GET")
+	r.HandleFunc("/{id}/location", auth.RequireDriverSelf(UpdateDriverLocationHandler, "id")).Methods("POST")
+	r.HandleFunc("/{id}/location", auth.RequireDriverSelf(GetDriverLocationHandler, "id")).Methods("GET")
+	r.HandleFunc("/{id}/locations", auth.RequireDriverSelf(idempotency.Middleware(UploadLocationBatchHandler), "id")).Methods("POST")
 }
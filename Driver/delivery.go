@@ -3,85 +3,219 @@ package Driver
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/idempotency"
+	"github.com/mangochops/coninx_backend/store"
 )
 
 // Delivery represents proof of completed dispatch by driver
-type Delivery struct {
-	ID         int `json:"id"`
-	DispatchID int `json:"dispatchId"`
-
-	Date   time.Time `json:"date"`
-	TripID int       `json:"tripId"`
-}
+type Delivery = store.Delivery
 
 // ---------------- CRUD ----------------
 
-// CreateDeliveryHandler inserts a new delivery record
+// errNotDeliverable is returned when a trip isn't ready to take a delivery record
+var errNotDeliverable = errors.New("trip must be delivered, or arrived with the OTP verified, before a delivery is recorded")
+
+// checkDeliverable returns errNotDeliverable unless t was completed by OTP, or
+// has arrived and its dispatch is verified
+func checkDeliverable(ctx context.Context, t *store.Trip) error {
+	switch {
+	case t.Status == store.TripDelivered:
+		return nil
+	case t.Status != store.TripArrived || t.DispatchID == 0:
+		return errNotDeliverable
+	}
+	ds, err := repo.Dispatches().Get(ctx, t.DispatchID)
+	if err != nil {
+		return err
+	}
+	if !ds.Verified {
+		return errNotDeliverable
+	}
+	return nil
+}
+
+// CreateDeliveryHandler inserts a new delivery record. It takes either JSON or a
+// multipart form carrying proof of delivery (see readPODForm). It only records;
+// trips are completed by OTP verification or the driver's finish action.
 func CreateDeliveryHandler(w http.ResponseWriter, r *http.Request) {
 	var d Delivery
-	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	var files []podFile
+	if isMultipart(r) {
+		var err error
+		if d, files, err = readPODForm(r); err != nil {
+			writePODError(w, err)
+			return
+		}
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if len(d.Recipient) > MaxPODTextLength || len(d.Condition) > MaxPODTextLength {
+			http.Error(w, fmt.Sprintf("Recipient and condition are limited to %d characters", MaxPODTextLength), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Failed and partial deliveries also move the trip and dispatch; see recordOutcome
+	if d.Status != "" && d.Status != store.DeliveryDelivered {
+		http.Error(w, "Record failed or partial deliveries through the trip's fail or partial endpoint", http.StatusBadRequest)
 		return
 	}
 
-	// Check if trip exists in admin.trips
-	var tripExists bool
-	err := db.QueryRow(
-		context.Background(),
-		`SELECT EXISTS(SELECT 1 FROM trips WHERE id=$1)`,
-		d.TripID,
-	).Scan(&tripExists)
+	// Check the trip exists and belongs to the caller
+	trip, err := repo.Trips().Get(r.Context(), d.TripID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Trip not found", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to check trip: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if !tripExists {
-		http.Error(w, "Trip not found", http.StatusBadRequest)
+	if !ownsTrip(r, trip) {
+		http.Error(w, "Trip belongs to another driver", http.StatusForbidden)
+		return
+	}
+	// Evidence is filed under the trip's dispatch, never one the client names
+	if d.DispatchID != 0 && d.DispatchID != trip.DispatchID {
+		http.Error(w, "dispatchId doesn't match the trip's dispatch", http.StatusBadRequest)
 		return
 	}
+	d.DispatchID = trip.DispatchID
 
-	err = db.QueryRow(
-		context.Background(),
-		`INSERT INTO deliveries (dispatch_id, trip_id, date)
-		 VALUES ($1, $2, NOW())
-		 RETURNING id, date`,
-		d.DispatchID, d.TripID,
-	).Scan(&d.ID, &d.Date)
+	// A delivery record never changes the trip or dispatch. It's only taken
+	// once the OTP has completed the trip, or once the trip has arrived and
+	// the dispatch is verified, ahead of the driver's finish
+	ctx := r.Context()
+	if err := checkDeliverable(ctx, trip); err != nil {
+		if errors.Is(err, errNotDeliverable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to check dispatch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := storePODFiles(ctx, d.DispatchID, files, &d)
 	if err != nil {
+		removeBlobs(keys)
+		http.Error(w, "Failed to store proof of delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := repo.Deliveries().Create(ctx, &d); err != nil {
+		removeBlobs(keys)
 		http.Error(w, "Failed to insert delivery: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(d)
+
+	events.Publish("delivery_created", map[string]interface{}{
+		"delivery": d,
+	})
 }
 
-// ListDeliveriesHandler returns all deliveries
-func ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query(
-		context.Background(),
-		`SELECT id, dispatch_id, trip_id, date
-		 FROM deliveries
-		 ORDER BY date DESC`,
-	)
+// AttachPODHandler adds a photo, signature, recipient, condition or note to an
+// existing delivery, e.g. one created by OTP verification. Fields left out keep
+// their current value.
+func AttachPODHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Failed to fetch deliveries: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	if !isMultipart(r) {
+		http.Error(w, "Expected multipart/form-data", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	pod, files, err := readPODForm(r)
+	if err != nil {
+		writePODError(w, err)
+		return
+	}
+
+	d, err := repo.Deliveries().Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok, err := ownsDelivery(r, d); err != nil {
+		http.Error(w, "Failed to check trip: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, "Trip belongs to another driver", http.StatusForbidden)
 		return
 	}
-	defer rows.Close()
 
+	keys, err := storePODFiles(r.Context(), d.DispatchID, files, &pod)
+	if err != nil {
+		removeBlobs(keys)
+		http.Error(w, "Failed to store proof of delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := repo.Deliveries().AttachPOD(r.Context(), id, pod)
+	if err != nil {
+		removeBlobs(keys)
+		http.Error(w, "Failed to update delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Files replaced by this upload are no longer referenced
+	var replaced []string
+	if pod.PhotoKey != "" && d.PhotoKey != "" {
+		replaced = append(replaced, d.PhotoKey)
+	}
+	if pod.SignatureKey != "" && d.SignatureKey != "" {
+		replaced = append(replaced, d.SignatureKey)
+	}
+	removeBlobs(replaced)
+
+	json.NewEncoder(w).Encode(updated)
+
+	events.Publish("delivery_updated", map[string]interface{}{
+		"delivery": updated,
+	})
+}
+
+// writePODError reports a rejected upload as 400 and anything else as 500
+func writePODError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBadPOD) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to read upload: "+err.Error(), http.StatusInternalServerError)
+}
+
+// ListDeliveriesHandler returns the caller's deliveries; admins see all of them
+func ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.FromContext(r.Context())
 	var list []Delivery
-	for rows.Next() {
-		var d Delivery
-		if err := rows.Scan(&d.ID, &d.DispatchID, &d.TripID, &d.Date); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		list = append(list, d)
+	var err error
+	if claims != nil && claims.Role == auth.RoleDriver {
+		list, err = repo.Deliveries().ListByDriver(r.Context(), claims.DriverID)
+	} else {
+		list, err = repo.Deliveries().List(r.Context())
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deliveries: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(list)
@@ -89,44 +223,53 @@ func ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetDeliveryHandler returns a single delivery by ID
 func GetDeliveryHandler(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
 
-	var d Delivery
-	err := db.QueryRow(
-		context.Background(),
-		`SELECT id, dispatch_id, trip_id, date
-		 FROM deliveries WHERE id=$1`,
-		id,
-	).Scan(&d.ID, &d.DispatchID, &d.TripID, &d.Date)
+	d, err := repo.Deliveries().Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Delivery not found", http.StatusNotFound)
 		return
 	}
+	if ok, err := ownsDelivery(r, d); err != nil {
+		http.Error(w, "Failed to check trip: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if !ok {
+		// Don't reveal other drivers' deliveries exist
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
 
 	json.NewEncoder(w).Encode(d)
 }
 
-// DeleteDeliveryHandler deletes a delivery by ID
-func DeleteDeliveryHandler(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
-
-	_, err := db.Exec(context.Background(),
-		`DELETE FROM deliveries WHERE id=$1`, id,
-	)
+// ownsDelivery reports whether the caller may see or amend d: admins always,
+// drivers only for deliveries on their own trips
+func ownsDelivery(r *http.Request, d *Delivery) (bool, error) {
+	if d.TripID == 0 {
+		claims, ok := auth.FromContext(r.Context())
+		return ok && claims.Role != auth.RoleDriver, nil
+	}
+	trip, err := repo.Trips().Get(r.Context(), d.TripID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
-		http.Error(w, "Failed to delete delivery: "+err.Error(), http.StatusInternalServerError)
-		return
+		return false, err
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	return ownsTrip(r, trip), nil
 }
 
 // ---------------- ROUTES ----------------
 
 // RegisterDeliveryRoutes adds delivery endpoints to router
 func RegisterDeliveryRoutes(r *mux.Router) {
-	r.HandleFunc("/driver/deliveries", CreateDeliveryHandler).Methods("POST")
+	// Bodies are capped before idempotency buffers them
+	r.HandleFunc("/driver/deliveries", limitBody(idempotency.Middleware(CreateDeliveryHandler), MaxPODRequestSize)).Methods("POST")
+	r.HandleFunc("/driver/deliveries/{id}/pod", limitBody(AttachPODHandler, MaxPODRequestSize)).Methods("PUT")
 	r.HandleFunc("/driver/deliveries", ListDeliveriesHandler).Methods("GET")
 	r.HandleFunc("/driver/deliveries/{id}", GetDeliveryHandler).Methods("GET")
-	r.HandleFunc("/driver/deliveries/{id}", DeleteDeliveryHandler).Methods("DELETE")
 }
@@ -0,0 +1,78 @@
+package Driver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+func TestCreateDeliveryOnlyRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		finish   string // status the trip is then moved to, if any
+		verified bool
+		wantCode int
+	}{
+		{name: "en route", from: store.TripEnRoute, wantCode: http.StatusConflict},
+		{name: "arrived without OTP", from: store.TripArrived, wantCode: http.StatusConflict},
+		{name: "arrived with OTP verified", from: store.TripArrived, verified: true, wantCode: http.StatusCreated},
+		{name: "delivered", from: store.TripArrived, finish: store.TripDelivered, verified: true, wantCode: http.StatusCreated},
+		{name: "failed", from: store.TripArrived, finish: store.TripFailed, wantCode: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := storetest.New()
+			InitStore(s)
+
+			driverID, trip := seedTrip(t, s, tt.from)
+			if tt.verified {
+				if err := s.Dispatches().MarkVerified(ctx, trip.DispatchID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.finish != "" {
+				var err error
+				if trip, err = s.Trips().Transition(ctx, trip.ID, tt.finish, "test", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before, err := s.Dispatches().Get(ctx, trip.DispatchID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"tripId":`+strconv.Itoa(trip.ID)+`}`))
+			r = r.WithContext(auth.NewContext(r.Context(), &auth.Claims{Role: auth.RoleDriver, DriverID: driverID}))
+			w := httptest.NewRecorder()
+			CreateDeliveryHandler(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+
+			after, err := s.Trips().Get(ctx, trip.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Status != trip.Status {
+				t.Errorf("trip status = %q, want it left at %q", after.Status, trip.Status)
+			}
+			ds, err := s.Dispatches().Get(ctx, trip.DispatchID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ds.Status != before.Status || ds.Verified != before.Verified {
+				t.Errorf("dispatch = %s/%v, want it left at %s/%v", ds.Status, ds.Verified, before.Status, before.Verified)
+			}
+		})
+	}
+}
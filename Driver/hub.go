@@ -0,0 +1,176 @@
+package Driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+var (
+	WSWriteWait      = 10 * time.Second    // max time to write one message
+	WSPongWait       = 60 * time.Second    // connection is dead if no pong within this
+	WSPingPeriod     = WSPongWait * 9 / 10 // must be shorter than WSPongWait
+	WSMaxMessageSize = int64(4096)
+	WSSendBuffer     = 32 // queued messages per client before it's evicted as slow
+)
+
+// wsClient is one socket. Only its writePump goroutine writes to conn.
+type wsClient struct {
+	conn     *websocket.Conn
+	send     chan []byte
+	driverID int // subscribed driver, 0 for none; guarded by hub.mu
+}
+
+// HubStats are counters for the driver WebSocket hub
+type HubStats struct {
+	ConnectedClients int    `json:"connectedClients"`
+	MessagesSent     uint64 `json:"messagesSent"`
+	MessagesDropped  uint64 `json:"messagesDropped"`
+	SlowEvictions    uint64 `json:"slowEvictions"`
+}
+
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	evictions atomic.Uint64
+}
+
+var hub = &wsHub{clients: make(map[*wsClient]struct{})}
+
+func (h *wsHub) register(conn *websocket.Conn) *wsClient {
+	c := &wsClient{conn: conn, send: make(chan []byte, WSSendBuffer)}
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+
+	go c.writePump()
+	return c
+}
+
+// unregister removes c and closes its queue, which stops its writePump
+func (h *wsHub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(c)
+}
+
+func (h *wsHub) removeLocked(c *wsClient) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+func (h *wsHub) subscribe(c *wsClient, driverID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.driverID = driverID
+}
+
+// enqueueLocked queues msg for c without blocking. A client whose queue is
+// full is evicted so one stalled phone can't hold up everyone else.
+func (h *wsHub) enqueueLocked(c *wsClient, msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		h.dropped.Add(1)
+		h.evictions.Add(1)
+		h.removeLocked(c)
+	}
+}
+
+// sendTo queues a message for a single client
+func (h *wsHub) sendTo(c *wsClient, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		h.enqueueLocked(c, msg)
+	}
+}
+
+// broadcast queues msg for every client subscribed to driverID
+func (h *wsHub) broadcast(driverID int, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		if c.driverID == driverID {
+			h.enqueueLocked(c, msg)
+		}
+	}
+}
+
+// Stats returns a snapshot of the hub counters
+func (h *wsHub) Stats() HubStats {
+	h.mu.Lock()
+	n := len(h.clients)
+	h.mu.Unlock()
+
+	return HubStats{
+		ConnectedClients: n,
+		MessagesSent:     h.sent.Load(),
+		MessagesDropped:  h.dropped.Load(),
+		SlowEvictions:    h.evictions.Load(),
+	}
+}
+
+// writePump is the only writer for c.conn. It drains the send queue and
+// pings on an interval; it exits when the queue is closed or a write fails.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(WSPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if !ok {
+				// Hub closed the queue (disconnect or eviction)
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+			hub.sent.Add(1)
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// prepareRead applies the read limit and keeps the read deadline moving while pongs arrive
+func (c *wsClient) prepareRead() {
+	c.conn.SetReadLimit(WSMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	})
+}
+
+// HubStatsHandler reports connected clients and dropped messages
+func HubStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.Stats())
+}
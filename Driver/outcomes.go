@@ -0,0 +1,191 @@
+package Driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
+)
+
+// validateOutcome checks the reason code and quantities for a failed or partial delivery
+func validateOutcome(d *store.Delivery) error {
+	if !store.ValidReasonCode(d.ReasonCode) {
+		return fmt.Errorf("reasonCode must be one of %s", strings.Join(store.ReasonCodes, ", "))
+	}
+	if d.ReasonCode == store.ReasonOther && d.Note == "" {
+		return errors.New("a note is required when reasonCode is other")
+	}
+	if len(d.Recipient) > MaxPODTextLength || len(d.Condition) > MaxPODTextLength {
+		return fmt.Errorf("recipient and condition are limited to %d characters", MaxPODTextLength)
+	}
+	if d.QuantityExpected < 0 || d.QuantityDelivered < 0 {
+		return errors.New("quantities can't be negative")
+	}
+
+	switch d.Status {
+	case store.DeliveryPartial:
+		if d.QuantityExpected == 0 || d.QuantityDelivered == 0 || d.QuantityDelivered >= d.QuantityExpected {
+			return errors.New("a partial delivery needs quantityExpected and a smaller, non-zero quantityDelivered")
+		}
+	case store.DeliveryFailed:
+		if d.QuantityDelivered != 0 {
+			return errors.New("nothing is delivered on a failed delivery; record it as partial instead")
+		}
+	}
+	return nil
+}
+
+// recordOutcome records a failed or partial delivery for the driver in {id} and
+// trip in {tripId}. The trip finishes (failed, or delivered for a partial drop),
+// a delivery is recorded with the reason and any photo, and the dispatch moves
+// to failed or partial so it can be rescheduled.
+func recordOutcome(w http.ResponseWriter, r *http.Request, status string) {
+	driverID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+	tripID, err := strconv.Atoi(mux.Vars(r)["tripId"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID", http.StatusBadRequest)
+		return
+	}
+
+	var d Delivery
+	var files []podFile
+	if isMultipart(r) {
+		if d, files, err = readPODForm(r); err != nil {
+			writePODError(w, err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	d.Status = status
+	d.TripID = tripID
+	if err := validateOutcome(&d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	trip, err := repo.Trips().Get(ctx, tripID)
+	if err == nil && trip.Driver.ID != driverID {
+		err = tracking.ErrNotOwner
+	}
+	if err == nil && trip.DispatchID == 0 {
+		err = errors.New("trip has no dispatch")
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	case errors.Is(err, tracking.ErrNotOwner):
+		http.Error(w, "Trip is not assigned to this driver", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.DispatchID = trip.DispatchID
+
+	// Fail fast before uploading; the transaction checks again under lock
+	tripStatus := store.TripFailed
+	if status == store.DeliveryPartial {
+		tripStatus = store.TripDelivered
+	}
+	if !store.CanTransition(trip.Status, tripStatus) {
+		http.Error(w, (&store.TransitionError{From: trip.Status, To: tripStatus}).Error(), http.StatusConflict)
+		return
+	}
+
+	keys, err := storePODFiles(ctx, d.DispatchID, files, &d)
+	if err != nil {
+		removeBlobs(keys)
+		http.Error(w, "Failed to store proof of delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dispatchStatus := store.DispatchFailed
+	if status == store.DeliveryPartial {
+		dispatchStatus = store.DispatchPartial
+	}
+	err = repo.InTx(ctx, func(tx store.Repo) error {
+		if err := tx.Dispatches().Lock(ctx, d.DispatchID); err != nil {
+			return err
+		}
+		if trip, err = tx.Trips().Transition(ctx, tripID, tripStatus, auth.Actor(ctx), d.ReasonCode); err != nil {
+			return err
+		}
+		if err := tx.Deliveries().Create(ctx, &d); err != nil {
+			return fmt.Errorf("create delivery: %w", err)
+		}
+		return tx.Dispatches().SetStatus(ctx, d.DispatchID, dispatchStatus)
+	})
+	var te *store.TransitionError
+	switch {
+	case err == nil:
+	case errors.Is(err, store.ErrNotFound):
+		removeBlobs(keys)
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return
+	case errors.As(err, &te):
+		removeBlobs(keys)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		removeBlobs(keys)
+		http.Error(w, "Failed to record delivery: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"trip":     trip,
+		"delivery": d,
+	})
+
+	tripEvent := "trip_failed"
+	if tripStatus == store.TripDelivered {
+		tripEvent = "trip_completed"
+	}
+	events.Publish(tripEvent, map[string]interface{}{
+		"trip":       trip,
+		"tripId":     trip.ID,
+		"dispatchId": trip.DispatchID,
+		"driverId":   driverID,
+		"status":     trip.Status,
+		"reason":     d.ReasonCode,
+	})
+	events.Publish("delivery_created", map[string]interface{}{
+		"delivery": d,
+	})
+	// The dashboard offers to reschedule on this
+	events.Publish("dispatch_"+dispatchStatus, map[string]interface{}{
+		"dispatchId": d.DispatchID,
+		"status":     dispatchStatus,
+		"reasonCode": d.ReasonCode,
+		"delivery":   d,
+	})
+}
+
+// FailDeliveryHandler records that nothing could be delivered, e.g. the
+// recipient was absent or refused the goods
+func FailDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	recordOutcome(w, r, store.DeliveryFailed)
+}
+
+// PartialDeliveryHandler records that only part of the dispatch was delivered
+func PartialDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	recordOutcome(w, r, store.DeliveryPartial)
+}
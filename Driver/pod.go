@@ -0,0 +1,203 @@
+package Driver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/blob"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// Proof-of-delivery upload limits
+var (
+	MaxPODFileSize    = int64(10 << 20) // per photo or signature
+	MaxPODRequestSize = int64(25 << 20) // whole multipart body
+	MaxPODTextLength  = 255             // recipient and condition columns
+)
+
+// podImageTypes maps accepted image types to the extension they're stored with
+var podImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var blobs blob.Store
+
+// InitBlobs sets where proof-of-delivery files are stored
+func InitBlobs(b blob.Store) {
+	blobs = b
+}
+
+// errBadPOD wraps problems with the uploaded form that are the client's fault
+var errBadPOD = errors.New("invalid proof of delivery")
+
+// podFile is an uploaded image waiting to be stored
+type podFile struct {
+	kind        string // photo or signature
+	data        []byte
+	contentType string
+}
+
+// isMultipart reports whether r carries a multipart form
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// limitBody caps the request body before anything (e.g. idempotency) reads it
+func limitBody(next http.HandlerFunc, n int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next(w, r)
+	}
+}
+
+// formInt reads an optional integer form field
+func formInt(r *http.Request, name string) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s must be a number", errBadPOD, name)
+	}
+	return n, nil
+}
+
+// readPODForm parses a multipart proof of delivery: tripId, dispatchId,
+// recipient, condition, note, reasonCode, quantityExpected and
+// quantityDelivered fields plus optional photo and signature images
+func readPODForm(r *http.Request) (store.Delivery, []podFile, error) {
+	var d store.Delivery
+	if err := r.ParseMultipartForm(MaxPODFileSize); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			return d, nil, fmt.Errorf("%w: upload larger than %d bytes", errBadPOD, MaxPODRequestSize)
+		}
+		return d, nil, fmt.Errorf("%w: %v", errBadPOD, err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var err error
+	if d.TripID, err = formInt(r, "tripId"); err != nil {
+		return d, nil, err
+	}
+	if d.DispatchID, err = formInt(r, "dispatchId"); err != nil {
+		return d, nil, err
+	}
+	if d.QuantityExpected, err = formInt(r, "quantityExpected"); err != nil {
+		return d, nil, err
+	}
+	if d.QuantityDelivered, err = formInt(r, "quantityDelivered"); err != nil {
+		return d, nil, err
+	}
+	d.ReasonCode = strings.TrimSpace(r.FormValue("reasonCode"))
+	d.Recipient = strings.TrimSpace(r.FormValue("recipient"))
+	d.Condition = strings.TrimSpace(r.FormValue("condition"))
+	d.Note = strings.TrimSpace(r.FormValue("note"))
+	if len(d.Recipient) > MaxPODTextLength || len(d.Condition) > MaxPODTextLength {
+		return d, nil, fmt.Errorf("%w: recipient and condition are limited to %d characters", errBadPOD, MaxPODTextLength)
+	}
+
+	var files []podFile
+	for _, kind := range []string{"photo", "signature"} {
+		fh, ok := r.MultipartForm.File[kind]
+		if !ok || len(fh) == 0 {
+			continue
+		}
+		f, err := readPODImage(kind, fh[0])
+		if err != nil {
+			return d, nil, err
+		}
+		files = append(files, f)
+	}
+	return d, files, nil
+}
+
+// readPODImage reads one uploaded image, checking its size and actual type
+func readPODImage(kind string, fh *multipart.FileHeader) (podFile, error) {
+	if fh.Size > MaxPODFileSize {
+		return podFile{}, fmt.Errorf("%w: %s larger than %d bytes", errBadPOD, kind, MaxPODFileSize)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return podFile{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxPODFileSize+1))
+	if err != nil {
+		return podFile{}, err
+	}
+	if int64(len(data)) > MaxPODFileSize {
+		return podFile{}, fmt.Errorf("%w: %s larger than %d bytes", errBadPOD, kind, MaxPODFileSize)
+	}
+
+	// Trust the bytes, not the client's Content-Type
+	contentType := http.DetectContentType(data)
+	if _, ok := podImageTypes[contentType]; !ok {
+		return podFile{}, fmt.Errorf("%w: %s must be a JPEG, PNG or WebP image", errBadPOD, kind)
+	}
+	return podFile{kind: kind, data: data, contentType: contentType}, nil
+}
+
+// storePODFiles uploads files for a dispatch and sets their keys on d.
+// It returns the keys written so a failed insert can clean them up.
+func storePODFiles(ctx context.Context, dispatchID int, files []podFile, d *store.Delivery) ([]string, error) {
+	if len(files) > 0 && blobs == nil {
+		return nil, errors.New("blob store not initialized")
+	}
+
+	var keys []string
+	for _, f := range files {
+		suffix := make([]byte, 8)
+		if _, err := rand.Read(suffix); err != nil {
+			return keys, err
+		}
+		key := fmt.Sprintf("pod/dispatch-%d/%s-%s%s", dispatchID, f.kind, hex.EncodeToString(suffix), podImageTypes[f.contentType])
+
+		if err := blobs.Put(ctx, key, f.data, f.contentType); err != nil {
+			return keys, fmt.Errorf("store %s: %w", f.kind, err)
+		}
+		keys = append(keys, key)
+
+		switch f.kind {
+		case "photo":
+			d.PhotoKey = key
+		case "signature":
+			d.SignatureKey = key
+		}
+	}
+	return keys, nil
+}
+
+// removeBlobs deletes files no delivery refers to, logging failures
+func removeBlobs(keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(context.Background(), key); err != nil {
+			log.Printf("[POD] Failed to remove orphaned %s: %v\n", key, err)
+		}
+	}
+}
+
+// ownsTrip reports whether the caller may record deliveries for t.
+// Drivers are limited to their own trips; admins may record any.
+func ownsTrip(r *http.Request, t *store.Trip) bool {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		return false
+	}
+	return claims.Role != auth.RoleDriver || claims.DriverID == t.Driver.ID
+}
@@ -2,12 +2,17 @@ package Driver
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
 )
 
 // Coordinates and Trip structs
@@ -22,47 +27,75 @@ type Trip struct {
 	DriverID      int         `json:"driverId"`
 	RecipientName string      `json:"recipientName"`
 	Invoice       int         `json:"invoice"`
-	Status        string      `json:"status"` // e.g., "requested", "in-progress", "completed"
+	Status        string      `json:"status"` // assigned, accepted, en_route, arrived, delivered, failed or cancelled
 	Coordinates   Coordinates `json:"coordinates"`
 }
 
-var (
-	trips   = make(map[int]Trip)
-	tripsMu sync.Mutex
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Location updates from any source (this socket, the admin API) reach
+// subscribed WS clients through the shared event stream
+func init() {
+	events.Subscribe(forwardLocationUpdate)
+	events.Subscribe(forwardReassignment)
+}
 
-	clients   = make(map[*websocket.Conn]int) // track subscribed driverId
-	clientsMu sync.Mutex
-	upgrader  = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+// tripFromStore converts a persisted trip to the shape sent over the socket
+func tripFromStore(t store.Trip) Trip {
+	return Trip{
+		ID:            t.ID,
+		Destination:   t.Destination,
+		DriverID:      t.Driver.ID,
+		RecipientName: t.RecipientName,
+		Status:        t.Status,
+		Coordinates:   Coordinates{Latitude: t.Latitude, Longitude: t.Longitude},
 	}
-)
+}
 
 // WebSocket handler
 func TripWSHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WS upgrade error:", err)
 		return
 	}
-	defer conn.Close()
 
-	// Register client with no subscription initially
-	clientsMu.Lock()
-	clients[conn] = 0
-	clientsMu.Unlock()
+	// Register client with no subscription initially; its write pump owns
+	// all writes and closes conn once we unregister
+	client := hub.register(conn)
+	defer hub.unregister(client)
+	client.prepareRead()
+
+	// Drivers act as themselves; admin tokens may watch and move any trip
+	ownDriverID := 0
+	if claims.Role == auth.RoleDriver {
+		ownDriverID = claims.DriverID
+	}
 
 	for {
-		_, msg, err := conn.ReadMessage()
+		_, msg, err := client.conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
 		var msgData struct {
-			Type      string  `json:"type"`
-			DriverID  int     `json:"driverId"`
-			TripID    int     `json:"tripId,omitempty"`
-			Latitude  float64 `json:"latitude,omitempty"`
-			Longitude float64 `json:"longitude,omitempty"`
+			Type       string    `json:"type"`
+			DriverID   int       `json:"driverId"`
+			TripID     int       `json:"tripId,omitempty"`
+			Latitude   float64   `json:"latitude,omitempty"`
+			Longitude  float64   `json:"longitude,omitempty"`
+			Accuracy   *float64  `json:"accuracy,omitempty"`
+			Speed      *float64  `json:"speed,omitempty"`
+			Heading    *float64  `json:"heading,omitempty"`
+			RecordedAt time.Time `json:"recordedAt,omitempty"`
 		}
 
 		if err := json.Unmarshal(msg, &msgData); err != nil {
@@ -72,47 +105,125 @@ func TripWSHandler(w http.ResponseWriter, r *http.Request) {
 
 		switch msgData.Type {
 		case "subscribe":
+			if ownDriverID != 0 && msgData.DriverID != ownDriverID {
+				sendWSError(client, "Cannot subscribe to another driver")
+				continue
+			}
+
 			// Subscribe client to a specific driver
-			clientsMu.Lock()
-			clients[conn] = msgData.DriverID
-			clientsMu.Unlock()
+			hub.subscribe(client, msgData.DriverID)
 
 		case "update_location":
-			// Update trip coordinates
-			tripsMu.Lock()
-			trip, ok := trips[msgData.TripID]
-			if ok {
-				trip.Coordinates = Coordinates{Latitude: msgData.Latitude, Longitude: msgData.Longitude}
-				trips[msgData.TripID] = trip
+			// Persist through the same path as PUT /admin/trips/{id}/location;
+			// the resulting location_update event fans out to WS and SSE
+			pos := store.Position{
+				Latitude:   msgData.Latitude,
+				Longitude:  msgData.Longitude,
+				Accuracy:   msgData.Accuracy,
+				Speed:      msgData.Speed,
+				Heading:    msgData.Heading,
+				RecordedAt: msgData.RecordedAt,
 			}
-			tripsMu.Unlock()
-
-			if ok {
-				broadcastDriverUpdate(trip)
+			_, err := tracking.UpdateTripLocation(r.Context(), msgData.TripID, ownDriverID, pos)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				sendWSError(client, "Trip not found or already finished")
+			case errors.Is(err, tracking.ErrNotOwner):
+				sendWSError(client, "Trip is not assigned to you")
+			case errors.Is(err, tracking.ErrInvalidFix):
+				sendWSError(client, "Coordinates out of range")
+			case err != nil:
+				log.Printf("WS location update for trip %d failed: %v\n", msgData.TripID, err)
+				sendWSError(client, "Failed to update location")
 			}
 		}
 	}
+}
 
-	// Remove client when disconnected
-	clientsMu.Lock()
-	delete(clients, conn)
-	clientsMu.Unlock()
+// sendWSError reports a rejected message back to the sender
+func sendWSError(c *wsClient, message string) {
+	hub.sendTo(c, map[string]string{"type": "error", "message": message})
 }
 
-// Broadcast only to clients subscribed to this driver
-func broadcastDriverUpdate(trip Trip) {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
+// forwardLocationUpdate relays location_update events to subscribed WS clients
+func forwardLocationUpdate(ev events.Event) {
+	if ev.Type != "location_update" {
+		return
+	}
 
-	data, _ := json.Marshal(trip)
-	for client, driverID := range clients {
-		if driverID == trip.DriverID {
-			client.WriteMessage(websocket.TextMessage, data)
+	var data struct {
+		Trip *store.Trip `json:"trip"`
+	}
+	if err := json.Unmarshal(ev.Data, &data); err != nil || data.Trip == nil {
+		return
+	}
+
+	broadcastDriverUpdate(tripFromStore(*data.Trip))
+}
+
+// forwardReassignment tells the drivers losing and gaining a dispatch about it,
+// whether it was reassigned or rescheduled after a failed delivery
+func forwardReassignment(ev events.Event) {
+	if ev.Type != "dispatch_reassigned" && ev.Type != "dispatch_rescheduled" {
+		return
+	}
+
+	var data struct {
+		DispatchID   int         `json:"dispatchId"`
+		FromDriverID int         `json:"fromDriverId"`
+		Trip         *store.Trip `json:"trip"`
+		PreviousTrip *store.Trip `json:"previousTrip"`
+	}
+	if err := json.Unmarshal(ev.Data, &data); err != nil || data.Trip == nil {
+		return
+	}
+
+	if data.FromDriverID != 0 && data.FromDriverID != data.Trip.Driver.ID {
+		msg := map[string]interface{}{
+			"type":       "trip_unassigned",
+			"dispatchId": data.DispatchID,
+		}
+		if data.PreviousTrip != nil {
+			msg["trip"] = tripFromStore(*data.PreviousTrip)
 		}
+		if b, err := json.Marshal(msg); err == nil {
+			hub.broadcast(data.FromDriverID, b)
+		}
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"type":       "trip_assigned",
+		"dispatchId": data.DispatchID,
+		"trip":       tripFromStore(*data.Trip),
+	})
+	if err == nil {
+		hub.broadcast(data.Trip.Driver.ID, b)
 	}
 }
 
+// Broadcast only to clients subscribed to this driver
+func broadcastDriverUpdate(trip Trip) {
+	data, _ := json.Marshal(trip)
+	hub.broadcast(trip.DriverID, data)
+}
+
 // Register trip routes (including WS)
 func RegisterTripRoutes(r *mux.Router) {
 	r.HandleFunc("/ws/trips", TripWSHandler)
+	r.HandleFunc("/ws/stats", auth.RequireRole(HubStatsHandler, auth.RoleAdmin)).Methods("GET")
+
+	// The driver's own work; numeric ids keep these clear of /ws/trips.
+	// Admins can list but only the assigned driver can act.
+	r.HandleFunc("/{id:[0-9]+}/trips", auth.RequireDriverSelf(ListDriverTripsHandler, "id")).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/accept", driverOnly(AcceptTripHandler)).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/reject", driverOnly(RejectTripHandler)).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/start", driverOnly(StartTripHandler)).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/finish", driverOnly(FinishTripHandler)).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/fail", limitBody(driverOnly(FailDeliveryHandler), MaxPODRequestSize)).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/trips/{tripId:[0-9]+}/partial", limitBody(driverOnly(PartialDeliveryHandler), MaxPODRequestSize)).Methods("POST")
+}
+
+// driverOnly limits a handler to the driver named by the {id} route variable
+func driverOnly(next http.HandlerFunc) http.HandlerFunc {
+	return auth.RequireRole(auth.RequireDriverSelf(next, "id"), auth.RoleDriver)
 }
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles carried in the access token
+const (
+	RoleAdmin  = "admin"
+	RoleDriver = "driver"
+)
+
+// AccessTokenTTL is how long an access token stays valid
+var AccessTokenTTL = 15 * time.Minute
+
+var jwtSecret []byte
+
+// Claims are the JWT claims issued by the admin and driver logins
+type Claims struct {
+	Role      string `json:"role"`
+	DriverID  int    `json:"driverId,omitempty"` // drivers.id, only set for drivers
+	SessionID int    `json:"sid"`                // refresh_tokens.id backing this token
+	AdminRole string `json:"-"`                  // admin_users.role, loaded per request by Middleware
+	jwt.RegisteredClaims
+}
+
+// InitSecret sets the HMAC key used to sign and verify tokens
+func InitSecret(secret string) error {
+	if secret == "" {
+		return errors.New("JWT secret is empty")
+	}
+	jwtSecret = []byte(secret)
+	return nil
+}
+
+// IssueAccessToken signs a short-lived token for the given role and user id.
+// For drivers, userID is drivers.id; for admins it is admin_users.id.
+func IssueAccessToken(role string, userID int, sessionID int) (string, time.Time, error) {
+	if jwtSecret == nil {
+		return "", time.Time{}, errors.New("JWT secret not initialized")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL)
+
+	claims := Claims{
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	if role == RoleDriver {
+		claims.DriverID = userID
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// ParseAccessToken verifies the signature and expiry and returns the claims
+func ParseAccessToken(tokenStr string) (*Claims, error) {
+	if jwtSecret == nil {
+		return nil, errors.New("JWT secret not initialized")
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// UserID returns the numeric subject of the token
+func (c *Claims) UserID() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Brute-force protection settings. A key may fail freely up to its threshold;
+// every failure after that locks it for LockoutBase doubled per extra failure,
+// capped at LockoutMax. Counters reset after FailureWindow without failures.
+var (
+	AccountFailureThreshold = 5
+	IPFailureThreshold      = 20
+	LockoutBase             = 30 * time.Second
+	LockoutMax              = 1 * time.Hour
+	FailureWindow           = 24 * time.Hour
+)
+
+// dummyHash gives unknown accounts the same bcrypt cost as real ones
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("coninx-no-such-account"), bcrypt.DefaultCost)
+
+// CompareDummyPassword burns one bcrypt comparison so a login for an unknown
+// account takes as long as a wrong password for a real one
+func CompareDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Lockout is a login_lockouts row
+type Lockout struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// AccountKey identifies a login account, e.g. "driver:12345678" or "admin:jane@example.com"
+func AccountKey(role, identifier string) string {
+	return role + ":" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// TrustedProxies is how many proxies in front of the server append to
+// X-Forwarded-For. Render terminates TLS at one; 0 ignores the header.
+var TrustedProxies = 1
+
+// IPKey identifies the client address. Entries left of those our proxies
+// appended are client-supplied and can't be trusted, so the key is the
+// TrustedProxies-th entry from the right, or RemoteAddr without proxies.
+func IPKey(r *http.Request) string {
+	if xff := r.Header.Values("X-Forwarded-For"); TrustedProxies > 0 && len(xff) > 0 {
+		entries := strings.Split(strings.Join(xff, ","), ",")
+		i := len(entries) - TrustedProxies
+		if i < 0 {
+			i = 0
+		}
+		if ip := strings.TrimSpace(entries[i]); ip != "" {
+			return "ip:" + ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func thresholdFor(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return IPFailureThreshold
+	}
+	return AccountFailureThreshold
+}
+
+// backoff returns how long a key is locked after its n-th consecutive failure
+func backoff(key string, failures int) time.Duration {
+	over := failures - thresholdFor(key)
+	if over <= 0 {
+		return 0
+	}
+	d := LockoutBase
+	for i := 1; i < over && d < LockoutMax; i++ {
+		d *= 2
+	}
+	if d > LockoutMax {
+		d = LockoutMax
+	}
+	return d
+}
+
+// CheckLockout returns how long until the caller may try again, or 0 if none of the keys are locked
+func CheckLockout(ctx context.Context, keys ...string) (time.Duration, error) {
+	if db == nil {
+		return 0, errors.New("auth DB not initialized")
+	}
+
+	var lockedUntil *time.Time
+	err := db.QueryRow(ctx,
+		`SELECT MAX(locked_until) FROM login_lockouts
+		 WHERE key = ANY($1) AND locked_until > NOW()`,
+		keys,
+	).Scan(&lockedUntil)
+	if err != nil || lockedUntil == nil {
+		return 0, err
+	}
+	return time.Until(*lockedUntil), nil
+}
+
+// RecordLoginFailure bumps the failure counter for each key and locks any
+// key that is over its threshold
+func RecordLoginFailure(ctx context.Context, keys ...string) error {
+	if db == nil {
+		return errors.New("auth DB not initialized")
+	}
+
+	for _, key := range keys {
+		var failures int
+		err := db.QueryRow(ctx,
+			`INSERT INTO login_lockouts (key, failures, last_failure_at)
+			 VALUES ($1, 1, NOW())
+			 ON CONFLICT (key) DO UPDATE SET
+			     failures = CASE WHEN login_lockouts.last_failure_at < $2 THEN 1
+			                     ELSE login_lockouts.failures + 1 END,
+			     last_failure_at = NOW()
+			 RETURNING failures`,
+			key, time.Now().Add(-FailureWindow),
+		).Scan(&failures)
+		if err != nil {
+			return err
+		}
+
+		if d := backoff(key, failures); d > 0 {
+			if _, err := db.Exec(ctx,
+				`UPDATE login_lockouts SET locked_until=$1 WHERE key=$2`,
+				time.Now().Add(d), key,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RecordLoginSuccess clears the failure counter for an account.
+// IP counters are left to decay so one valid login can't reset a spraying client.
+func RecordLoginSuccess(ctx context.Context, accountKey string) error {
+	return ClearLockout(ctx, accountKey)
+}
+
+// ListLockouts returns keys that are locked now or have recent failures
+func ListLockouts(ctx context.Context) ([]Lockout, error) {
+	if db == nil {
+		return nil, errors.New("auth DB not initialized")
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT key, failures, last_failure_at, locked_until FROM login_lockouts
+		 WHERE locked_until > NOW() OR last_failure_at > $1
+		 ORDER BY last_failure_at DESC`,
+		time.Now().Add(-FailureWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Lockout
+	for rows.Next() {
+		var l Lockout
+		if err := rows.Scan(&l.Key, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// ClearLockout forgets all failures for a key
+func ClearLockout(ctx context.Context, key string) error {
+	if db == nil {
+		return errors.New("auth DB not initialized")
+	}
+	_, err := db.Exec(ctx, `DELETE FROM login_lockouts WHERE key=$1`, key)
+	return err
+}
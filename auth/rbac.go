@@ -0,0 +1,62 @@
+package auth
+
+import "net/http"
+
+// Admin roles stored in admin_users.role
+const (
+	AdminOwner      = "owner"
+	AdminDispatcher = "dispatcher"
+	AdminViewer     = "viewer"
+)
+
+// Permission guards a group of admin endpoints
+type Permission string
+
+const (
+	PermDispatch       Permission = "dispatch"        // create/update/delete dispatches and trips, OTP
+	PermManageVehicles Permission = "manage_vehicles" // create/update vehicles
+	PermDeleteVehicles Permission = "delete_vehicles"
+	PermManageDrivers  Permission = "manage_drivers" // driver accounts and sessions
+	PermManageAdmins   Permission = "manage_admins"
+	PermManageLockouts Permission = "manage_lockouts" // view/clear login lockouts
+)
+
+// Viewers have no write permissions; every admin can read
+var rolePermissions = map[string][]Permission{
+	AdminOwner: {
+		PermDispatch, PermManageVehicles, PermDeleteVehicles, PermManageDrivers, PermManageAdmins,
+		PermManageLockouts,
+	},
+	AdminDispatcher: {
+		PermDispatch, PermManageVehicles, PermManageDrivers, PermManageLockouts,
+	},
+	AdminViewer: {},
+}
+
+// ValidAdminRole reports whether role is a known admin role
+func ValidAdminRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the admin role grants perm
+func HasPermission(adminRole string, perm Permission) bool {
+	for _, p := range rolePermissions[adminRole] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission wraps an admin handler so only roles granting perm may call it
+func RequirePermission(next http.HandlerFunc, perm Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		if !ok || claims.Role != RoleAdmin || !HasPermission(claims.AdminRole, perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
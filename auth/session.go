@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RefreshTokenTTL is how long a session survives without a refresh.
+// Drivers stay signed in on shared phones for weeks.
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+var db *pgxpool.Pool
+
+// InitDB sets the DB pool used for sessions
+func InitDB(pool *pgxpool.Pool) {
+	db = pool
+}
+
+// TokenPair is returned by login and refresh
+type TokenPair struct {
+	AccessToken      string    `json:"accessToken"`
+	RefreshToken     string    `json:"refreshToken"`
+	TokenType        string    `json:"tokenType"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+}
+
+// NewOpaqueToken returns a random URL-safe token and the hash we store for it.
+// Used for refresh tokens and single-use invite/reset tokens.
+func NewOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new refresh token for the user and issues the first token pair
+func CreateSession(ctx context.Context, role string, userID int) (*TokenPair, error) {
+	if db == nil {
+		return nil, errors.New("auth DB not initialized")
+	}
+
+	refresh, hash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := time.Now().Add(RefreshTokenTTL)
+
+	var sessionID int
+	err = db.QueryRow(ctx,
+		`INSERT INTO refresh_tokens (role, user_id, token_hash, expires_at)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id`,
+		role, userID, hash, refreshExpiresAt,
+	).Scan(&sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return issuePair(role, userID, sessionID, refresh, refreshExpiresAt)
+}
+
+// RefreshSession rotates the refresh token and issues a new token pair.
+// The old refresh token stops working as soon as this returns.
+func RefreshSession(ctx context.Context, role string, refreshToken string) (*TokenPair, error) {
+	if db == nil {
+		return nil, errors.New("auth DB not initialized")
+	}
+
+	refresh, newHash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshExpiresAt := time.Now().Add(RefreshTokenTTL)
+
+	var sessionID, userID int
+	err = db.QueryRow(ctx,
+		`UPDATE refresh_tokens
+		 SET token_hash=$1, expires_at=$2, last_used_at=NOW()
+		 WHERE token_hash=$3 AND role=$4 AND revoked_at IS NULL AND expires_at > NOW()
+		 RETURNING id, user_id`,
+		newHash, refreshExpiresAt, HashToken(refreshToken), role,
+	).Scan(&sessionID, &userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return issuePair(role, userID, sessionID, refresh, refreshExpiresAt)
+}
+
+func issuePair(role string, userID int, sessionID int, refresh string, refreshExpiresAt time.Time) (*TokenPair, error) {
+	access, expiresAt, err := IssueAccessToken(role, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresAt:        expiresAt,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// RevokeSession ends a single session (logout)
+func RevokeSession(ctx context.Context, sessionID int) error {
+	if db == nil {
+		return errors.New("auth DB not initialized")
+	}
+	_, err := db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL`,
+		sessionID)
+	return err
+}
+
+// RevokeAllSessions ends every session for a user, e.g. a lost driver phone.
+// It returns how many sessions were revoked.
+func RevokeAllSessions(ctx context.Context, role string, userID int) (int64, error) {
+	if db == nil {
+		return 0, errors.New("auth DB not initialized")
+	}
+	tag, err := db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at=NOW()
+		 WHERE role=$1 AND user_id=$2 AND revoked_at IS NULL`,
+		role, userID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// loadSession reports whether the session behind an access token is still live.
+// For admin sessions it also returns the admin's current role, so role changes
+// and deleted accounts take effect without waiting for the token to expire.
+func loadSession(ctx context.Context, sessionID int) (bool, string, error) {
+	if db == nil {
+		return false, "", errors.New("auth DB not initialized")
+	}
+
+	var active bool
+	var adminRole string
+	err := db.QueryRow(ctx,
+		`SELECT rt.revoked_at IS NULL AND rt.expires_at > NOW(), COALESCE(au.role, '')
+		 FROM refresh_tokens rt
+		 LEFT JOIN admin_users au ON rt.role='admin' AND au.id=rt.user_id
+		 WHERE rt.id=$1`,
+		sessionID,
+	).Scan(&active, &adminRole)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, "", nil
+	}
+	return active, adminRole, err
+}
@@ -0,0 +1,57 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned by Get and Delete for a missing key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps uploaded files (proof-of-delivery photos and signatures)
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the object and its content type; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FromEnv picks a store from BLOB_STORE ("s3" or "local", default "local")
+func FromEnv() Store {
+	switch os.Getenv("BLOB_STORE") {
+	case "s3":
+		return &S3Store{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    envOr("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		}
+	default:
+		return &LocalStore{Dir: envOr("BLOB_DIR", "./uploads")}
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// validKey rejects keys that could escape the store's root
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/Driver"
+	"github.com/mangochops/coninx_backend/migrations"
+)
+
+// runCommand handles one-off subcommands, e.g. `./server migrate up`.
+// It returns false when args don't name a known command so the server starts normally.
+func runCommand(args []string, pool *pgxpool.Pool) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "migrate":
+		migrate(args[1:], pool)
+	case "password-audit":
+		passwordAudit()
+	default:
+		return false
+	}
+	return true
+}
+
+// migrate runs `migrate up`, `migrate down [steps]` or `migrate status`
+func migrate(args []string, pool *pgxpool.Pool) {
+	ctx := context.Background()
+
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		ran, err := migrations.Up(ctx, pool)
+		for _, m := range ran {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migrate up failed: %v\n", err)
+		}
+		if len(ran) == 0 {
+			fmt.Println("Database is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid step count %q\n", args[1])
+			}
+			steps = n
+		}
+		ran, err := migrations.Down(ctx, pool, steps)
+		for _, m := range ran {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migrate down failed: %v\n", err)
+		}
+
+	case "status":
+		list, err := migrations.List(ctx, pool)
+		if err != nil {
+			log.Fatalf("Migrate status failed: %v\n", err)
+		}
+		for _, s := range list {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-32s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		log.Fatalf("Unknown migrate action %q (use up, down [steps] or status)\n", action)
+	}
+}
+
+// passwordAudit reports how many drivers still have a plaintext password.
+// Those rows are rehashed automatically on the driver's next successful login.
+func passwordAudit() {
+	n, err := Driver.CountPlaintextPasswords(context.Background())
+	if err != nil {
+		log.Fatalf("Password audit failed: %v\n", err)
+	}
+
+	fmt.Printf("Drivers with unhashed passwords: %d\n", n)
+}
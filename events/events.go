@@ -0,0 +1,255 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stream settings. ReplaySize bounds how many past events a reconnecting
+// client can catch up on via Last-Event-ID.
+var (
+	ReplaySize        = 1024
+	HeartbeatInterval = 15 * time.Second
+	ClientBuffer      = 64
+)
+
+// Event is one message on the admin event stream
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+type broker struct {
+	mu        sync.Mutex
+	clients   map[chan Event]struct{}
+	ring      []Event // oldest first, at most ReplaySize
+	nextID    uint64
+	listeners []func(Event)
+}
+
+// IDs are seeded from the clock so they keep increasing across restarts,
+// letting a client reconnecting after a deploy detect the gap
+var b = &broker{
+	clients: make(map[chan Event]struct{}),
+	nextID:  uint64(time.Now().UnixMilli()) * 1000,
+}
+
+// Publish sends an event to every connected client and keeps it for replay.
+// The JSON data is payload plus a "type" field, matching what the dashboard
+// has always parsed. Once Listen is running the event goes out through
+// Postgres NOTIFY so every instance delivers it, this one included.
+func Publish(eventType string, payload map[string]interface{}) {
+	data := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		data[k] = v
+	}
+	data["type"] = eventType
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Println("[Events] marshal error:", err)
+		return
+	}
+
+	if listening.Load() {
+		err := notify(eventType, raw)
+		if err == nil {
+			return
+		}
+		// e.g. payload over the 8000 byte NOTIFY limit: at least reach local clients
+		log.Println("[Events] notify failed, delivering locally without an ID:", err)
+	}
+
+	// A local ID would collide with the shared sequence once other
+	// instances are numbering events
+	if shared.Load() {
+		b.deliverUnnumbered(Event{Type: eventType, Data: raw})
+		return
+	}
+	b.deliver(Event{Type: eventType, Data: raw})
+}
+
+// deliver hands ev to local clients and listeners and keeps it for replay.
+// Events without an ID get the next local one.
+func (b *broker) deliver(ev Event) {
+	b.mu.Lock()
+	if ev.ID == 0 {
+		b.nextID++
+		ev.ID = b.nextID
+	} else if ev.ID > b.nextID {
+		b.nextID = ev.ID
+	}
+
+	b.ring = append(b.ring, ev)
+	if len(b.ring) > ReplaySize {
+		b.ring = b.ring[len(b.ring)-ReplaySize:]
+	}
+	listeners := b.send(ev)
+	b.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+// deliverUnnumbered hands ev to local clients and listeners with no ID, so
+// it is neither replayed nor counted by a client's Last-Event-ID
+func (b *broker) deliverUnnumbered(ev Event) {
+	b.mu.Lock()
+	listeners := b.send(ev)
+	b.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+// send queues ev for every client and returns the listeners to call.
+// b.mu must be held.
+func (b *broker) send(ev Event) []func(Event) {
+	for ch := range b.clients {
+		select {
+		case ch <- ev:
+		default:
+			// Too slow: drop the client so it reconnects and replays what it missed
+			delete(b.clients, ch)
+			close(ch)
+		}
+	}
+	return b.listeners
+}
+
+// Subscribe registers fn to be called with every published event, e.g. to
+// feed the driver WebSocket hub. fn runs on the publisher's goroutine and
+// must not block.
+func Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// subscribe registers a client and returns the buffered events after lastID.
+// gap is true when lastID is older than anything still buffered.
+func (b *broker) subscribe(lastID uint64, hasLastID bool) (ch chan Event, backlog []Event, gap bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch = make(chan Event, ClientBuffer)
+	b.clients[ch] = struct{}{}
+
+	if !hasLastID {
+		return ch, nil, false
+	}
+
+	for _, ev := range b.ring {
+		if ev.ID > lastID {
+			backlog = append(backlog, ev)
+		}
+	}
+
+	oldest := b.nextID + 1
+	if len(b.ring) > 0 {
+		oldest = b.ring[0].ID
+	}
+	gap = lastID+1 < oldest
+	return ch, backlog, gap
+}
+
+func (b *broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// lastEventID reads the Last-Event-ID header, which EventSource sends on
+// reconnect, or the lastEventId query parameter for a fresh page load
+func lastEventID(r *http.Request) (uint64, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("lastEventId")
+	}
+	if v == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	return id, err == nil
+}
+
+// writeEvent writes ev as one SSE message. Unnumbered events carry no id
+// line, which leaves the client's Last-Event-ID where it was.
+func writeEvent(w http.ResponseWriter, ev Event) error {
+	if ev.ID == 0 {
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data)
+	return err
+}
+
+// Handler streams events as Server-Sent Events, with heartbeats and
+// Last-Event-ID replay
+func Handler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, hasLastID := lastEventID(r)
+	ch, backlog, gap := b.subscribe(lastID, hasLastID)
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+
+	// The buffer no longer reaches back far enough; tell the dashboard to refetch
+	if gap {
+		fmt.Fprintf(w, "event: resync\ndata: {\"type\":\"resync\"}\n\n")
+	}
+	for _, ev := range backlog {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package events
+
+import "testing"
+
+func TestPublishWithoutSharedIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		shared     bool // IDs already come from the shared sequence
+		wantID     bool
+		wantReplay bool
+	}{
+		{"single instance numbers locally", false, true, true},
+		{"shared sequence in use", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared.Store(tt.shared)
+			defer shared.Store(false)
+
+			before := b.nextID
+			ch, _, _ := b.subscribe(0, false)
+			defer b.unsubscribe(ch)
+
+			Publish("trip_updated", map[string]interface{}{"tripId": 1})
+			ev := <-ch
+
+			if got := ev.ID != 0; got != tt.wantID {
+				t.Errorf("event ID = %d, want numbered = %v", ev.ID, tt.wantID)
+			}
+			replay, backlog, _ := b.subscribe(before, true)
+			b.unsubscribe(replay)
+			if got := len(backlog) > 0; got != tt.wantReplay {
+				t.Errorf("replayed after %d = %v, want %v", before, got, tt.wantReplay)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// channel is the Postgres NOTIFY channel shared by every instance
+const channel = "coninx_events"
+
+var (
+	db        *pgxpool.Pool
+	listening atomic.Bool // true while the LISTEN connection is up
+	shared    atomic.Bool // set once IDs come from the shared sequence
+)
+
+// InitDB sets the pool used to NOTIFY other instances
+func InitDB(pool *pgxpool.Pool) {
+	db = pool
+}
+
+// envelope is the NOTIFY payload
+type envelope struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// notify publishes an event to every instance. The ID comes from a shared
+// sequence so Last-Event-ID means the same thing on every replica.
+func notify(eventType string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.Exec(ctx,
+		`SELECT pg_notify($1, json_build_object(
+		     'id', nextval('event_ids'), 'type', $2::text, 'data', $3::json)::text)`,
+		channel, eventType, string(data))
+	return err
+}
+
+// Listen feeds events NOTIFYed by any instance into the local SSE clients
+// and listeners until ctx is cancelled, reconnecting on failure.
+// connString should reach Postgres directly; transaction poolers drop LISTEN.
+// While the connection is down, Publish delivers locally only, without an ID.
+func Listen(ctx context.Context, connString string) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := listenOnce(ctx, connString)
+		if listening.Swap(false) {
+			backoff = time.Second // was connected; start over
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("[Events] LISTEN connection lost, retrying in %s: %v\n", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func listenOnce(ctx context.Context, connString string) error {
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	if db != nil {
+		listening.Store(true)
+		shared.Store(true)
+	}
+	log.Println("[Events] Listening for events from all instances")
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var env envelope
+		if err := json.Unmarshal([]byte(n.Payload), &env); err != nil {
+			log.Println("[Events] bad notification:", err)
+			continue
+		}
+		b.deliver(Event{ID: env.ID, Type: env.Type, Data: env.Data})
+	}
+}
@@ -0,0 +1,296 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/auth"
+)
+
+// Header is the request header carrying the client's idempotency key
+const Header = "Idempotency-Key"
+
+// TTL is how long a stored response is replayed for the same key
+var TTL = 24 * time.Hour
+
+// MaxKeyLength bounds the header so it fits idempotency_keys.key
+const MaxKeyLength = 255
+
+var db *pgxpool.Pool
+
+// InitDB sets the DB pool used to store responses
+func InitDB(pool *pgxpool.Pool) {
+	db = pool
+}
+
+// stored is a previously seen request for a key
+type stored struct {
+	requestHash string
+	statusCode  *int
+	contentType string
+	body        []byte
+}
+
+// Middleware wraps a POST handler so a retry carrying the same Idempotency-Key
+// gets the first response replayed instead of running the handler again.
+// Requests without the header run normally.
+func Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > MaxKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+		if db == nil {
+			http.Error(w, "Database not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := scopeFor(r)
+		hash := requestHash(r, body)
+
+		claimed, err := claim(r.Context(), scope, key, hash)
+		if err != nil {
+			http.Error(w, "Failed to check idempotency key", http.StatusInternalServerError)
+			log.Println("[Idempotency] Claim error:", err)
+			return
+		}
+
+		if !claimed {
+			prev, err := load(r.Context(), scope, key)
+			if err != nil {
+				http.Error(w, "Failed to check idempotency key", http.StatusInternalServerError)
+				log.Println("[Idempotency] Load error:", err)
+				return
+			}
+			switch {
+			case prev.requestHash != hash:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+			case prev.statusCode == nil:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				replay(w, prev)
+			}
+			return
+		}
+
+		// A handler that panics or never returns leaves no response to store;
+		// free the key so retries aren't refused as in progress until it expires
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := release(ctx, scope, key); err != nil {
+				log.Println("[Idempotency] Failed to release key:", err)
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		completed = true
+
+		// Don't pin server errors to the key; let the client retry for real
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if rec.status >= http.StatusInternalServerError {
+			err = release(ctx, scope, key)
+		} else {
+			err = save(ctx, scope, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		}
+		if err != nil {
+			log.Println("[Idempotency] Failed to store response:", err)
+		}
+	}
+}
+
+// scopeFor keeps keys from different callers apart
+func scopeFor(r *http.Request) string {
+	if claims, ok := auth.FromContext(r.Context()); ok {
+		return claims.Role + ":" + strconv.Itoa(claims.UserID())
+	}
+	return "anonymous"
+}
+
+// requestHash fingerprints the request so a reused key with a different body is caught
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	if !hashMultipart(h, r, body) {
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashMultipart writes each part's field name, file name and content digest
+// to h, leaving out the boundary, which clients pick afresh on every retry.
+// It reports false if body isn't a readable multipart form.
+func hashMultipart(h io.Writer, r *http.Request, body []byte) bool {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return false
+	}
+
+	var parts bytes.Buffer
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false
+		}
+		digest := sha256.New()
+		if _, err := io.Copy(digest, p); err != nil {
+			return false
+		}
+		fmt.Fprintf(&parts, "%q %q %x\n", p.FormName(), p.FileName(), digest.Sum(nil))
+	}
+	h.Write(parts.Bytes())
+	return true
+}
+
+// claim reserves the key for this request. It returns false if a live
+// (unexpired) row already holds the key. Expired rows are taken over.
+func claim(ctx context.Context, scope, key, hash string) (bool, error) {
+	var id int
+	err := db.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (scope, key) DO UPDATE SET
+		     request_hash = EXCLUDED.request_hash,
+		     status_code = NULL,
+		     content_type = NULL,
+		     response_body = NULL,
+		     created_at = NOW(),
+		     expires_at = EXCLUDED.expires_at
+		 WHERE idempotency_keys.expires_at <= NOW()
+		 RETURNING id`,
+		scope, key, hash, time.Now().Add(TTL),
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func load(ctx context.Context, scope, key string) (*stored, error) {
+	var s stored
+	var contentType *string
+	err := db.QueryRow(ctx,
+		`SELECT request_hash, status_code, content_type, response_body
+		 FROM idempotency_keys WHERE scope=$1 AND key=$2`,
+		scope, key,
+	).Scan(&s.requestHash, &s.statusCode, &contentType, &s.body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != nil {
+		s.contentType = *contentType
+	}
+	return &s, nil
+}
+
+func save(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	_, err := db.Exec(ctx,
+		`UPDATE idempotency_keys SET status_code=$1, content_type=$2, response_body=$3
+		 WHERE scope=$4 AND key=$5`,
+		status, contentType, body, scope, key)
+	return err
+}
+
+func release(ctx context.Context, scope, key string) error {
+	_, err := db.Exec(ctx, `DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2`, scope, key)
+	return err
+}
+
+func replay(w http.ResponseWriter, s *stored) {
+	if s.contentType != "" {
+		w.Header().Set("Content-Type", s.contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*s.statusCode)
+	w.Write(s.body)
+}
+
+// Purge deletes expired keys and returns how many were removed
+func Purge(ctx context.Context) (int64, error) {
+	if db == nil {
+		return 0, errors.New("idempotency DB not initialized")
+	}
+	tag, err := db.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// PurgeEvery runs Purge on an interval until ctx is cancelled
+func PurgeEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := Purge(ctx); err != nil {
+				log.Println("[Idempotency] Purge error:", err)
+			} else if n > 0 {
+				log.Printf("[Idempotency] Purged %d expired keys\n", n)
+			}
+		}
+	}
+}
+
+// recorder passes the response through and keeps a copy to store
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,56 @@
+package idempotency
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// podRequest builds a multipart POST with a recipient field and a photo
+func podRequest(t *testing.T, boundary, recipient, photo string) (*http.Request, []byte) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	mw.WriteField("recipient", recipient)
+	fw, err := mw.CreateFormFile("photo", "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(photo))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/driver/driver/deliveries", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r, body.Bytes()
+}
+
+func TestRequestHashMultipart(t *testing.T) {
+	base := func() string {
+		r, body := podRequest(t, "boundary-one", "Jane", "jpeg bytes")
+		return requestHash(r, body)
+	}()
+
+	tests := []struct {
+		name      string
+		boundary  string
+		recipient string
+		photo     string
+		wantSame  bool
+	}{
+		{"same form, new boundary", "boundary-two", "Jane", "jpeg bytes", true},
+		{"different field", "boundary-one", "John", "jpeg bytes", false},
+		{"different file", "boundary-one", "Jane", "other bytes", false},
+	}
+
+	for _, tt := range tests {
+		r, body := podRequest(t, tt.boundary, tt.recipient, tt.photo)
+		if got := requestHash(r, body) == base; got != tt.wantSame {
+			t.Errorf("%s: same hash = %v, want %v", tt.name, got, tt.wantSame)
+		}
+	}
+}
@@ -6,12 +6,24 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/mangochops/coninx_backend/Admin"
 	"github.com/mangochops/coninx_backend/Driver"
+	"github.com/mangochops/coninx_backend/auth"
+	"github.com/mangochops/coninx_backend/blob"
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/idempotency"
+	"github.com/mangochops/coninx_backend/migrations"
+	"github.com/mangochops/coninx_backend/notify"
+	"github.com/mangochops/coninx_backend/otp"
+	"github.com/mangochops/coninx_backend/phone"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/tracking"
 	"github.com/rs/cors"
 )
 
@@ -26,8 +38,8 @@ func main() {
 		log.Fatal("DB_URL environment variable not set")
 	}
 
-	// Connect to PostgreSQL using pgxpool
-	pool, err := pgxpool.New(context.Background(), dbURL)
+	// Connect to PostgreSQL with the one pool shared by every package
+	pool, err := store.NewPool(context.Background(), dbURL)
 	if err != nil {
 		log.Fatalf("Unable to create connection pool: %v\n", err)
 	}
@@ -41,9 +53,82 @@ func main() {
 		log.Fatalf("Failed to set search path: %v\n", err)
 	}
 
-	// Initialize DB connections for packages
-	Admin.InitDBPool(dbURL)
-	Driver.InitDB(pool)
+	// Initialize DB access for packages
+	repo := store.New(pool)
+	Admin.InitStore(repo)
+	Driver.InitStore(repo)
+	tracking.Init(repo)
+	auth.InitDB(pool)
+	idempotency.InitDB(pool)
+	events.InitDB(pool)
+	Admin.InitNotifier(notify.FromEnv())
+	Admin.InitOTP(otp.FromEnv(pool, notify.SMSFromEnv()))
+
+	// Proof-of-delivery photos and signatures
+	blobs := blob.FromEnv()
+	Admin.InitBlobs(blobs)
+	Driver.InitBlobs(blobs)
+
+	// One-off commands (e.g. `./server migrate up`) run and exit
+	if runCommand(os.Args[1:], pool) {
+		return
+	}
+
+	// Optionally bring the schema up to date before serving
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		ran, err := migrations.Up(context.Background(), pool)
+		if err != nil {
+			log.Fatalf("Failed to run migrations: %v\n", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", len(ran))
+	}
+
+	// Signing key for access tokens
+	if err := auth.InitSecret(os.Getenv("JWT_SECRET")); err != nil {
+		log.Fatalf("JWT_SECRET environment variable not set: %v\n", err)
+	}
+
+	// How long a stored response is replayed for a retried Idempotency-Key
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("Invalid IDEMPOTENCY_TTL %q\n", v)
+		}
+		idempotency.TTL = ttl
+	}
+	go idempotency.PurgeEvery(context.Background(), time.Hour)
+
+	// Proxies whose X-Forwarded-For entries are trusted for per-IP login lockouts
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid TRUSTED_PROXIES %q\n", v)
+		}
+		auth.TrustedProxies = n
+	}
+
+	// Delivery OTP limits; OTP_TTL and OTP_MAX_ATTEMPTS also configure the self-hosted provider
+	Admin.OTPResendCooldown = envDuration("OTP_RESEND_COOLDOWN", Admin.OTPResendCooldown)
+	Admin.OTPMaxSends = envInt("OTP_MAX_SENDS_PER_HOUR", Admin.OTPMaxSends)
+	Admin.OTPMaxVerifyAttempts = envInt("OTP_MAX_ATTEMPTS", Admin.OTPMaxVerifyAttempts)
+	Admin.OTPCodeTTL = envDuration("OTP_TTL", Admin.OTPCodeTTL)
+
+	// Country assumed for recipient phones written without one
+	if v := os.Getenv("DEFAULT_COUNTRY_CODE"); v != "" {
+		code := strings.TrimPrefix(v, "+")
+		if !phone.ValidCountryCode(code) {
+			log.Fatalf("Invalid DEFAULT_COUNTRY_CODE %q\n", v)
+		}
+		phone.DefaultCountryCode = code
+	}
+
+	// Fan events out to every replica. LISTEN needs a direct connection,
+	// so DB_LISTEN_URL can bypass a transaction pooler.
+	listenURL := os.Getenv("DB_LISTEN_URL")
+	if listenURL == "" {
+		listenURL = dbURL
+	}
+	go events.Listen(context.Background(), listenURL)
 
 	// Test the connection
 	var result int
@@ -58,14 +143,27 @@ func main() {
 	router := mux.NewRouter()
 
 	// --- Namespaced routers ---
+	// Public routers are registered first so login never hits the auth middleware
+	adminPublic := router.PathPrefix("/admin").Subrouter()
+	driverPublic := router.PathPrefix("/driver").Subrouter()
+
 	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(auth.Middleware(auth.RoleAdmin))
+
 	driverRouter := router.PathPrefix("/driver").Subrouter()
+	driverRouter.Use(auth.Middleware(auth.RoleAdmin, auth.RoleDriver))
+
+	// --- Public auth routes ---
+	Admin.RegisterAuthRoutes(adminPublic)
+	Driver.RegisterAuthRoutes(driverPublic)
 
 	// --- Admin routes ---
-	Admin.RegisterAuthRoutes(adminRouter)
+	Admin.RegisterSessionRoutes(adminRouter)
+	Admin.RegisterAdminUserRoutes(adminRouter)
 	Admin.RegisterDispatchRoutes(adminRouter)
 	Admin.RegisterVehicleRoutes(adminRouter)
 	Admin.RegisterTripRoutes(adminRouter)
+	Driver.RegisterAdminRoutes(adminRouter)
 
 	// --- Driver routes ---
 	Driver.RegisterDriverRoutes(driverRouter)
@@ -83,7 +181,7 @@ func main() {
 			"http://localhost:8081",
 		},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Idempotency-Key", "Last-Event-ID"},
 		AllowCredentials: true,
 		Debug:            true, // shows preflight logs in terminal
 	})
@@ -97,3 +195,29 @@ func main() {
 	fmt.Printf("Server running on :%s\n", port)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, c.Handler(router)))
 }
+
+// envDuration reads a positive Go duration from key, or returns fallback if unset
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s %q\n", key, v)
+	}
+	return d
+}
+
+// envInt reads a positive integer from key, or returns fallback if unset
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s %q\n", key, v)
+	}
+	return n
+}
@@ -0,0 +1,245 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// advisoryLockID keeps two instances from migrating at the same time
+const advisoryLockID = 7347001
+
+// Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Load reads the embedded migrations ordered by version
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, desc, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_description", name)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: desc}
+			byVersion[version] = m
+		} else if m.Name != desc {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, desc)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// withLock runs fn on a single connection holding the migration advisory lock
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgx.Conn) error) error {
+	c, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	conn := c.Conn()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID)
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func applied(ctx context.Context, conn *pgx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		done[v] = at
+	}
+	return done, rows.Err()
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+// It returns the migrations that were applied.
+func Up(ctx context.Context, pool *pgxpool.Pool) ([]Migration, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range all {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx,
+					`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+					m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+			}
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// Down rolls back the most recent steps applied migrations.
+// It returns the migrations that were rolled back.
+func Down(ctx context.Context, pool *pgxpool.Pool, steps int) ([]Migration, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && len(ran) < steps; i-- {
+			m := all[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+			}
+			ran = append(ran, m)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// List reports every known migration and when it was applied
+func List(ctx context.Context, pool *pgxpool.Pool) ([]Status, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Status
+	err = withLock(ctx, pool, func(conn *pgx.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			s := Status{Version: m.Version, Name: m.Name}
+			if at, ok := done[m.Version]; ok {
+				s.AppliedAt = &at
+			}
+			list = append(list, s)
+		}
+		return nil
+	})
+	return list, err
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS deliveries;
+DROP TABLE IF EXISTS trips;
+DROP TABLE IF EXISTS dispatches;
+DROP TABLE IF EXISTS vehicles;
+DROP TABLE IF EXISTS drivers;
+DROP TABLE IF EXISTS admin_users;
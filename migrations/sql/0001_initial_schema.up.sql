@@ -1,8 +1,5 @@
--- ==========================
--- Coninx Backend Schema for Neon
--- ==========================
-
-SET search_path TO public;
+-- Baseline schema as originally shipped in schema.sql.
+-- Uses IF NOT EXISTS so databases created from schema.sql can adopt migrations.
 
 -- --------------------------
 -- Admin Users Table
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_deliveries_dispatch_id;
+
+UPDATE deliveries SET recipient = '' WHERE recipient IS NULL;
+ALTER TABLE deliveries ALTER COLUMN recipient SET NOT NULL;
+ALTER TABLE deliveries DROP COLUMN IF EXISTS date;
+ALTER TABLE deliveries DROP COLUMN IF EXISTS dispatch_id;
+
+ALTER TABLE trips DROP COLUMN IF EXISTS recipient_name;
+ALTER TABLE trips DROP COLUMN IF EXISTS destination;
+
+ALTER TABLE drivers DROP COLUMN IF EXISTS longitude;
+ALTER TABLE drivers DROP COLUMN IF EXISTS latitude;
+ALTER TABLE drivers DROP COLUMN IF EXISTS phone_number;
+ALTER TABLE drivers DROP COLUMN IF EXISTS last_name;
+ALTER TABLE drivers DROP COLUMN IF EXISTS first_name;
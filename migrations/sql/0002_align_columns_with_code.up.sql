@@ -0,0 +1,22 @@
+-- The handlers drifted from schema.sql; add the columns they actually use.
+
+-- Driver.RegisterHandler / GetDriversHandler / location handlers
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS first_name VARCHAR(100);
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS last_name VARCHAR(100);
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS phone_number BIGINT;
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+
+-- Carry over the old single name column
+UPDATE drivers SET first_name = name WHERE first_name IS NULL AND name IS NOT NULL;
+
+-- AutoCreateTrip
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS destination VARCHAR(500);
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS recipient_name VARCHAR(255);
+
+-- VerifyOTP / CreateDeliveryHandler insert (dispatch_id, trip_id, date) only
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS dispatch_id INTEGER REFERENCES dispatches(id) ON DELETE CASCADE;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS date TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE deliveries ALTER COLUMN recipient DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_dispatch_id ON deliveries(dispatch_id);
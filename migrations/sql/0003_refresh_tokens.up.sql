@@ -0,0 +1,13 @@
+-- One row per login session
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    role VARCHAR(20) NOT NULL,            -- 'admin' or 'driver'
+    user_id INTEGER NOT NULL,             -- admin_users.id or drivers.id
+    token_hash CHAR(64) UNIQUE NOT NULL,  -- SHA-256 of the opaque refresh token
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(role, user_id);
@@ -0,0 +1,5 @@
+-- Existing admins predate roles and were all full admins: keep them as owners.
+-- New accounts default to read-only.
+ALTER TABLE admin_users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'owner'
+    CHECK (role IN ('owner', 'dispatcher', 'viewer'));
+ALTER TABLE admin_users ALTER COLUMN role SET DEFAULT 'viewer';
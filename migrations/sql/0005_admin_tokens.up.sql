@@ -0,0 +1,12 @@
+-- Single-use invite / password-reset tokens
+CREATE TABLE IF NOT EXISTS admin_tokens (
+    id SERIAL PRIMARY KEY,
+    purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('invite', 'reset')),
+    email VARCHAR(100) NOT NULL,
+    role VARCHAR(20),                     -- role granted by an invite
+    token_hash CHAR(64) UNIQUE NOT NULL,  -- SHA-256 of the emailed token
+    created_by INTEGER REFERENCES admin_users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
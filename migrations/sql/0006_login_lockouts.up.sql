@@ -0,0 +1,7 @@
+-- Failed-login counters per account and per IP
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    key VARCHAR(255) PRIMARY KEY,         -- 'driver:<id_number>', 'admin:<email>' or 'ip:<addr>'
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP
+);
@@ -0,0 +1,15 @@
+-- First response stored per Idempotency-Key so client retries can be replayed
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(100) NOT NULL,          -- caller, e.g. 'driver:12' or 'admin:3'
+    key VARCHAR(255) NOT NULL,            -- client-supplied Idempotency-Key header
+    request_hash CHAR(64) NOT NULL,       -- SHA-256 of method, path and body
+    status_code INTEGER,                  -- NULL while the first request is in flight
+    content_type VARCHAR(255),
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    UNIQUE (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
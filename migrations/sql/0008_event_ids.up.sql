@@ -0,0 +1,8 @@
+-- Event IDs shared by every instance, so Last-Event-ID replay works whichever
+-- replica a dashboard reconnects to. Seeded from the clock like the in-process
+-- IDs it replaces, so IDs clients already hold stay lower.
+CREATE SEQUENCE IF NOT EXISTS event_ids;
+SELECT setval('event_ids', GREATEST(
+    (SELECT last_value FROM event_ids),
+    (EXTRACT(EPOCH FROM NOW()) * 1000)::BIGINT * 1000
+));
@@ -0,0 +1,16 @@
+-- Every position report, so a trip's actual route can be played back
+CREATE TABLE IF NOT EXISTS trip_positions (
+    id BIGSERIAL PRIMARY KEY,
+    trip_id INTEGER REFERENCES trips(id) ON DELETE CASCADE,
+    driver_id INTEGER REFERENCES drivers(id) ON DELETE SET NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    accuracy DOUBLE PRECISION,            -- metres
+    speed DOUBLE PRECISION,               -- metres per second
+    heading DOUBLE PRECISION,             -- degrees clockwise from north
+    recorded_at TIMESTAMP NOT NULL,       -- device time of the fix
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_positions_trip ON trip_positions(trip_id, recorded_at);
+CREATE INDEX IF NOT EXISTS idx_trip_positions_driver ON trip_positions(driver_id, recorded_at);
@@ -0,0 +1,4 @@
+ALTER TABLE trips DROP COLUMN IF EXISTS arrived_at;
+ALTER TABLE dispatches DROP COLUMN IF EXISTS geofence_radius;
+ALTER TABLE dispatches DROP COLUMN IF EXISTS dest_longitude;
+ALTER TABLE dispatches DROP COLUMN IF EXISTS dest_latitude;
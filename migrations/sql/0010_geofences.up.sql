@@ -0,0 +1,7 @@
+-- Destination coordinates and arrival radius per dispatch
+ALTER TABLE dispatches ADD COLUMN IF NOT EXISTS dest_latitude DOUBLE PRECISION;
+ALTER TABLE dispatches ADD COLUMN IF NOT EXISTS dest_longitude DOUBLE PRECISION;
+ALTER TABLE dispatches ADD COLUMN IF NOT EXISTS geofence_radius DOUBLE PRECISION;  -- metres
+
+-- When the trip entered the destination geofence
+ALTER TABLE trips ADD COLUMN IF NOT EXISTS arrived_at TIMESTAMP;
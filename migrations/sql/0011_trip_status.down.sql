@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS trip_status_history;
+
+ALTER TABLE trips DROP CONSTRAINT IF EXISTS trips_status_check;
+ALTER TABLE trips ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE trips ALTER COLUMN status SET DEFAULT 'started';
+UPDATE trips SET status='completed' WHERE status IN ('delivered', 'failed', 'cancelled');
+UPDATE trips SET status='started' WHERE status IN ('assigned', 'accepted', 'en_route');
@@ -0,0 +1,24 @@
+-- Trip lifecycle: assigned -> accepted -> en_route -> arrived -> delivered / failed / cancelled
+UPDATE trips SET status='assigned' WHERE status IS NULL OR status IN ('started', 'requested');
+UPDATE trips SET status='en_route' WHERE status IN ('in-progress', 'in_progress');
+UPDATE trips SET status='delivered' WHERE status='completed';
+-- Anything else was a client typo
+UPDATE trips SET status='assigned'
+WHERE status NOT IN ('assigned', 'accepted', 'en_route', 'arrived', 'delivered', 'failed', 'cancelled');
+
+ALTER TABLE trips ALTER COLUMN status SET DEFAULT 'assigned';
+ALTER TABLE trips ALTER COLUMN status SET NOT NULL;
+ALTER TABLE trips ADD CONSTRAINT trips_status_check
+    CHECK (status IN ('assigned', 'accepted', 'en_route', 'arrived', 'delivered', 'failed', 'cancelled'));
+
+-- Every status change, who made it and when
+CREATE TABLE IF NOT EXISTS trip_status_history (
+    id SERIAL PRIMARY KEY,
+    trip_id INTEGER NOT NULL REFERENCES trips(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    actor VARCHAR(50) NOT NULL,           -- 'admin:<id>', 'driver:<id>' or 'system'
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_status_history_trip ON trip_status_history(trip_id, created_at);
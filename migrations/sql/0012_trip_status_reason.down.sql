@@ -0,0 +1 @@
+ALTER TABLE trip_status_history DROP COLUMN IF EXISTS reason;
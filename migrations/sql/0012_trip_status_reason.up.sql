@@ -0,0 +1,2 @@
+-- Why a trip was rejected, failed or cancelled
+ALTER TABLE trip_status_history ADD COLUMN IF NOT EXISTS reason TEXT;
@@ -0,0 +1,22 @@
+-- Who held each dispatch and when; the open row (ended_at IS NULL) is the current holder
+CREATE TABLE IF NOT EXISTS dispatch_assignments (
+    id SERIAL PRIMARY KEY,
+    dispatch_id INTEGER NOT NULL REFERENCES dispatches(id) ON DELETE CASCADE,
+    driver_id INTEGER REFERENCES drivers(id) ON DELETE SET NULL,
+    vehicle_id INTEGER REFERENCES vehicles(id) ON DELETE SET NULL,
+    trip_id INTEGER REFERENCES trips(id) ON DELETE SET NULL,
+    assigned_by VARCHAR(50) NOT NULL,     -- 'admin:<id>' or 'system'
+    reason TEXT,
+    assigned_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dispatch_assignments_dispatch ON dispatch_assignments(dispatch_id, assigned_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dispatch_assignments_open ON dispatch_assignments(dispatch_id) WHERE ended_at IS NULL;
+
+-- Existing dispatches start with their current driver
+INSERT INTO dispatch_assignments (dispatch_id, driver_id, vehicle_id, trip_id, assigned_by, assigned_at)
+SELECT d.id, d.driver_id, d.vehicle_id,
+       (SELECT MAX(t.id) FROM trips t WHERE t.dispatch_id = d.id),
+       'system', COALESCE(d.date, NOW())
+FROM dispatches d;
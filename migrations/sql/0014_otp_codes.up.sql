@@ -0,0 +1,13 @@
+-- Delivery OTPs issued by the self-hosted provider. Only a bcrypt hash of each code is kept.
+CREATE TABLE IF NOT EXISTS otp_codes (
+    id SERIAL PRIMARY KEY,
+    ref VARCHAR(100) NOT NULL,            -- what the code unlocks, e.g. 'dispatch:42'
+    phone VARCHAR(20) NOT NULL,
+    code_hash VARCHAR(100) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP,                -- verified or superseded by a newer code
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_otp_codes_ref ON otp_codes(ref, phone) WHERE consumed_at IS NULL;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dispatch_otp_log;
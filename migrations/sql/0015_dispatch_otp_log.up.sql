@@ -0,0 +1,14 @@
+-- Every OTP send and verify attempt per dispatch, for rate limits and disputes
+CREATE TABLE IF NOT EXISTS dispatch_otp_log (
+    id SERIAL PRIMARY KEY,
+    dispatch_id INTEGER NOT NULL REFERENCES dispatches(id) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL,          -- 'send' or 'verify'
+    outcome VARCHAR(20) NOT NULL,         -- sent, send_failed, rate_limited, approved, rejected, expired, locked, error
+    phone VARCHAR(20),
+    actor VARCHAR(50) NOT NULL,
+    detail TEXT,
+    expires_at TIMESTAMP,                 -- when a sent code stops working
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dispatch_otp_log_dispatch ON dispatch_otp_log(dispatch_id, created_at);
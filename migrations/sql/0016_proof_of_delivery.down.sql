@@ -0,0 +1,3 @@
+ALTER TABLE deliveries ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE deliveries DROP COLUMN IF EXISTS signature_key;
+ALTER TABLE deliveries DROP COLUMN IF EXISTS photo_key;
@@ -0,0 +1,7 @@
+-- Proof of delivery files live in the blob store; only their keys are kept here
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS photo_key VARCHAR(255);
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS signature_key VARCHAR(255);
+
+-- Every delivery recorded so far was completed
+UPDATE deliveries SET status='delivered' WHERE status IS NULL OR status='pending';
+ALTER TABLE deliveries ALTER COLUMN status SET DEFAULT 'delivered';
@@ -0,0 +1,7 @@
+ALTER TABLE deliveries DROP COLUMN IF EXISTS quantity_delivered;
+ALTER TABLE deliveries DROP COLUMN IF EXISTS quantity_expected;
+ALTER TABLE deliveries DROP COLUMN IF EXISTS reason_code;
+
+DROP INDEX IF EXISTS idx_dispatches_status;
+ALTER TABLE dispatches DROP CONSTRAINT IF EXISTS dispatches_status_check;
+ALTER TABLE dispatches DROP COLUMN IF EXISTS status;
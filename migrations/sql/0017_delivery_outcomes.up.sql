@@ -0,0 +1,11 @@
+-- Dispatch outcome: pending -> delivered, or failed / partial and awaiting a rescheduled trip
+ALTER TABLE dispatches ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'pending';
+UPDATE dispatches SET status='delivered' WHERE verified;
+ALTER TABLE dispatches ADD CONSTRAINT dispatches_status_check
+    CHECK (status IN ('pending', 'delivered', 'partial', 'failed'));
+CREATE INDEX IF NOT EXISTS idx_dispatches_status ON dispatches(status);
+
+-- Why a delivery failed or fell short, and how much of it arrived
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS reason_code VARCHAR(32);
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS quantity_expected INTEGER;
+ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS quantity_delivered INTEGER;
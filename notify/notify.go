@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an outgoing email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to people (invites, password resets)
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv picks a notifier from NOTIFIER ("smtp" or "log", default "log")
+func FromEnv() Notifier {
+	switch os.Getenv("NOTIFIER") {
+	case "smtp":
+		return &SMTPNotifier{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envOr("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	default:
+		return &LogNotifier{Path: os.Getenv("NOTIFY_LOG_FILE")}
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ---------------- SMTP ----------------
+
+// SMTPNotifier sends email through an SMTP server (production)
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	if n.Host == "" || n.From == "" {
+		return fmt.Errorf("smtp notifier not configured")
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		msg.Body,
+	}, "\r\n")
+
+	return smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{msg.To}, []byte(body))
+}
+
+// ---------------- Log / file sink ----------------
+
+// LogNotifier writes messages to the server log, and to Path if set (local testing)
+type LogNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	log.Printf("[Notify] To: %s | Subject: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+
+	if n.Path == "" {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SMS is an outgoing text message
+type SMS struct {
+	To   string // E.164
+	Body string
+}
+
+// SMSSender delivers text messages (delivery OTPs)
+type SMSSender interface {
+	SendSMS(ctx context.Context, msg SMS) error
+}
+
+// SMSFromEnv picks an SMS gateway from SMS_GATEWAY ("http" or "log", default "log")
+func SMSFromEnv() SMSSender {
+	switch os.Getenv("SMS_GATEWAY") {
+	case "http":
+		return &HTTPSMSSender{
+			URL:   os.Getenv("SMS_GATEWAY_URL"),
+			Token: os.Getenv("SMS_GATEWAY_TOKEN"),
+			From:  os.Getenv("SMS_FROM"),
+		}
+	default:
+		return &LogSMSSender{Path: os.Getenv("SMS_LOG_FILE")}
+	}
+}
+
+// ---------------- HTTP gateway ----------------
+
+// HTTPSMSSender POSTs {"to", "from", "body"} as JSON to any gateway that
+// accepts it, with an optional bearer token. Most providers (or a small
+// adapter in front of them) can take this shape.
+type HTTPSMSSender struct {
+	URL    string
+	Token  string
+	From   string
+	Client *http.Client // defaults to a client with a 10s timeout
+}
+
+func (s *HTTPSMSSender) SendSMS(ctx context.Context, msg SMS) error {
+	if s.URL == "" {
+		return fmt.Errorf("sms gateway not configured")
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"to":   msg.To,
+		"from": s.From,
+		"body": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ---------------- Log / file sink ----------------
+
+// LogSMSSender writes texts to the server log, and to Path if set (local testing)
+type LogSMSSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *LogSMSSender) SendSMS(ctx context.Context, msg SMS) error {
+	log.Printf("[SMS] To: %s | %s\n", msg.To, msg.Body)
+
+	if s.Path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "--- %s\nTo: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), msg.To, msg.Body)
+	return err
+}
@@ -0,0 +1,69 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/notify"
+	"github.com/twilio/twilio-go"
+)
+
+var (
+	// ErrNoCode is returned by Check when no unexpired code is pending
+	ErrNoCode = errors.New("no pending code, request a new one")
+	// ErrTooManyAttempts is returned by Check once a code has been guessed at too often
+	ErrTooManyAttempts = errors.New("too many attempts, request a new code")
+)
+
+// Provider sends one-time codes to a phone and checks them. ref names what
+// the code unlocks (e.g. "dispatch:42") so one phone can hold codes for
+// several deliveries.
+type Provider interface {
+	// Send issues a new code and returns the provider's status, e.g. "pending"
+	Send(ctx context.Context, ref, phone string) (string, error)
+	// Check reports whether code is the pending code for ref and phone
+	Check(ctx context.Context, ref, phone, code string) (bool, error)
+}
+
+// FromEnv picks a provider from OTP_PROVIDER:
+//
+//	twilio       Twilio Verify (default)
+//	self-hosted  codes generated here, stored hashed in Postgres, sent through sms
+//	fake         in-memory, for local development and tests
+func FromEnv(pool *pgxpool.Pool, sms notify.SMSSender) Provider {
+	switch os.Getenv("OTP_PROVIDER") {
+	case "self-hosted":
+		p := &SelfHostedProvider{DB: pool, SMS: sms}
+		if v := os.Getenv("OTP_TTL"); v != "" {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl <= 0 {
+				log.Fatalf("Invalid OTP_TTL %q\n", v)
+			}
+			p.TTL = ttl
+		}
+		if v := os.Getenv("OTP_MAX_ATTEMPTS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid OTP_MAX_ATTEMPTS %q\n", v)
+			}
+			p.MaxAttempts = n
+		}
+		return p
+	case "fake":
+		log.Println("[OTP] Using the in-memory fake provider; codes are logged, not texted")
+		return &FakeProvider{Code: os.Getenv("OTP_FAKE_CODE")}
+	default:
+		return &TwilioProvider{
+			Client: twilio.NewRestClientWithParams(twilio.ClientParams{
+				Username: os.Getenv("TWILIO_ACCOUNT_SID"),
+				Password: os.Getenv("TWILIO_AUTH_TOKEN"),
+			}),
+			ServiceSid: os.Getenv("TWILIO_VERIFY_SERVICE_SID"),
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mangochops/coninx_backend/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Self-hosted defaults
+const (
+	DefaultTTL         = 10 * time.Minute
+	DefaultMaxAttempts = 5
+	codeDigits         = 6
+)
+
+// SelfHostedProvider generates codes itself, keeps only their bcrypt hash
+// in otp_codes and texts them through any SMS gateway. A new code
+// supersedes the previous one for the same ref and phone.
+type SelfHostedProvider struct {
+	DB          *pgxpool.Pool
+	SMS         notify.SMSSender
+	TTL         time.Duration // defaults to DefaultTTL
+	MaxAttempts int           // wrong guesses allowed per code; defaults to DefaultMaxAttempts
+}
+
+// newCode returns a uniformly random numeric code
+func newCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n.Int64()), nil
+}
+
+func (p *SelfHostedProvider) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultTTL
+}
+
+func (p *SelfHostedProvider) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (p *SelfHostedProvider) Send(ctx context.Context, ref, phone string) (string, error) {
+	code, err := newCode()
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := p.ttl()
+	err = pgx.BeginFunc(ctx, p.DB, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`UPDATE otp_codes SET consumed_at=NOW()
+			 WHERE ref=$1 AND phone=$2 AND consumed_at IS NULL`,
+			ref, phone); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx,
+			`INSERT INTO otp_codes (ref, phone, code_hash, expires_at)
+			 VALUES ($1, $2, $3, $4)`,
+			ref, phone, string(hash), time.Now().Add(ttl))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Stored first so a code that reached the phone can always be checked
+	msg := notify.SMS{
+		To:   phone,
+		Body: fmt.Sprintf("Your Coninx delivery code is %s. It expires in %d minutes.", code, int(ttl.Minutes())),
+	}
+	if err := p.SMS.SendSMS(ctx, msg); err != nil {
+		return "", fmt.Errorf("send sms: %w", err)
+	}
+	return "pending", nil
+}
+
+func (p *SelfHostedProvider) Check(ctx context.Context, ref, phone, code string) (bool, error) {
+	ok := false
+	err := pgx.BeginFunc(ctx, p.DB, func(tx pgx.Tx) error {
+		var id, attempts int
+		var hash string
+		err := tx.QueryRow(ctx,
+			`SELECT id, code_hash, attempts FROM otp_codes
+			 WHERE ref=$1 AND phone=$2 AND consumed_at IS NULL AND expires_at > NOW()
+			 ORDER BY id DESC LIMIT 1
+			 FOR UPDATE`,
+			ref, phone,
+		).Scan(&id, &hash, &attempts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNoCode
+		}
+		if err != nil {
+			return err
+		}
+		if attempts >= p.maxAttempts() {
+			return ErrTooManyAttempts
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+			_, err := tx.Exec(ctx, `UPDATE otp_codes SET attempts=attempts+1 WHERE id=$1`, id)
+			return err
+		}
+
+		ok = true
+		_, err = tx.Exec(ctx, `UPDATE otp_codes SET consumed_at=NOW() WHERE id=$1`, id)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
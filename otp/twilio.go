@@ -0,0 +1,39 @@
+package otp
+
+import (
+	"context"
+
+	"github.com/twilio/twilio-go"
+	openapi "github.com/twilio/twilio-go/rest/verify/v2"
+)
+
+// TwilioProvider delegates codes to a Twilio Verify service. Twilio keys
+// verifications by phone, so ref is not sent.
+type TwilioProvider struct {
+	Client     *twilio.RestClient
+	ServiceSid string
+}
+
+func (p *TwilioProvider) Send(ctx context.Context, ref, phone string) (string, error) {
+	params := &openapi.CreateVerificationParams{}
+	params.SetTo(phone)
+	params.SetChannel("sms")
+
+	resp, err := p.Client.VerifyV2.CreateVerification(p.ServiceSid, params)
+	if err != nil {
+		return "", err
+	}
+	return *resp.Status, nil
+}
+
+func (p *TwilioProvider) Check(ctx context.Context, ref, phone, code string) (bool, error) {
+	params := &openapi.CreateVerificationCheckParams{}
+	params.SetTo(phone)
+	params.SetCode(code)
+
+	resp, err := p.Client.VerifyV2.CreateVerificationCheck(p.ServiceSid, params)
+	if err != nil {
+		return false, err
+	}
+	return *resp.Status == "approved", nil
+}
@@ -0,0 +1,77 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for numbers that can't be read as E.164
+var ErrInvalid = errors.New("invalid phone number")
+
+// DefaultCountryCode is assumed for numbers written without one (Kenya)
+var DefaultCountryCode = "254"
+
+// E.164 allows at most 15 digits including the country code
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// Normalize converts a phone number as people type it into E.164, e.g.
+// "0712 345 678", "712345678", "254712345678" and "+254 712-345-678" all
+// become "+254712345678". Numbers without a country code get
+// DefaultCountryCode; "00" is read as the international prefix.
+func Normalize(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		international, s = true, s[1:]
+	case strings.HasPrefix(s, "00"):
+		international, s = true, s[2:]
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// formatting
+		default:
+			return "", ErrInvalid
+		}
+	}
+	digits := b.String()
+
+	if !international {
+		switch {
+		case strings.HasPrefix(digits, "0"):
+			// National trunk prefix
+			digits = DefaultCountryCode + strings.TrimLeft(digits, "0")
+		case strings.HasPrefix(digits, DefaultCountryCode) && len(digits) > len(DefaultCountryCode)+6:
+			// Country code without the plus
+		default:
+			digits = DefaultCountryCode + digits
+		}
+	}
+
+	if len(digits) < minDigits || len(digits) > maxDigits || digits[0] == '0' {
+		return "", ErrInvalid
+	}
+	return "+" + digits, nil
+}
+
+// ValidCountryCode reports whether code is a 1-3 digit calling code without the plus
+func ValidCountryCode(code string) bool {
+	if len(code) < 1 || len(code) > 3 || code[0] == '0' {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
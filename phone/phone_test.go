@@ -0,0 +1,61 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{raw: "0712 345 678", want: "+254712345678"},
+		{raw: "712345678", want: "+254712345678"},
+		{raw: "254712345678", want: "+254712345678"},
+		{raw: "+254 712-345-678", want: "+254712345678"},
+		{raw: "00254712345678", want: "+254712345678"},
+		{raw: "  (0712) 345.678 ", want: "+254712345678"},
+		{raw: "+1 415 555 0100", want: "+14155550100"},
+		{raw: "0044 20 7946 0958", want: "+442079460958"},
+		{raw: "", err: true},
+		{raw: "+", err: true},
+		{raw: "0712abc678", err: true},
+		{raw: "+0712345678", err: true},
+		{raw: "+1234567", err: true},
+		{raw: "+1234567890123456", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Normalize(%q) = %q, want error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDefaultCountryCode(t *testing.T) {
+	defer func(code string) { DefaultCountryCode = code }(DefaultCountryCode)
+	DefaultCountryCode = "256"
+
+	got, err := Normalize("0772 123 456")
+	if err != nil || got != "+256772123456" {
+		t.Errorf("Normalize with default 256 = %q, %v; want +256772123456", got, err)
+	}
+}
+
+func TestValidCountryCode(t *testing.T) {
+	tests := map[string]bool{
+		"1": true, "44": true, "254": true,
+		"": false, "0": false, "044": false, "1234": false, "+1": false, "2a": false,
+	}
+	for code, want := range tests {
+		if got := ValidCountryCode(code); got != want {
+			t.Errorf("ValidCountryCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
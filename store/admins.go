@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrDuplicate is returned when an insert hits a unique constraint, e.g. an email already registered
+var ErrDuplicate = errors.New("already exists")
+
+// AdminUser is an admin_users row without the password
+type AdminUser struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+}
+
+// AdminUserStore persists dashboard accounts
+type AdminUserStore interface {
+	// CreateFirstOwner adds u as the owner only while there are no admins yet, and reports whether it did
+	CreateFirstOwner(ctx context.Context, u *AdminUser, passwordHash string) (bool, error)
+	Create(ctx context.Context, u *AdminUser, passwordHash string) error
+	// Credentials returns the id, role and password hash for an email
+	Credentials(ctx context.Context, email string) (int, string, string, error)
+	Exists(ctx context.Context, email string) (bool, error)
+	List(ctx context.Context) ([]AdminUser, error)
+	// SetRole returns ErrNotFound if there is no such admin or it would leave no owners
+	SetRole(ctx context.Context, id int, role string) (*AdminUser, error)
+	// SetPassword sets the password for an email and returns the admin's id
+	SetPassword(ctx context.Context, email, passwordHash string) (int, error)
+	// Delete returns ErrNotFound if there is no such admin or it is the last owner
+	Delete(ctx context.Context, id int) error
+}
+
+// AdminToken is a single-use invite or password-reset token
+type AdminToken struct {
+	Purpose   string
+	Email     string
+	Role      string // granted by an invite, empty for a reset
+	TokenHash string
+	CreatedBy *int
+	ExpiresAt time.Time
+}
+
+// AdminTokenStore persists invite and reset tokens
+type AdminTokenStore interface {
+	Create(ctx context.Context, t *AdminToken) error
+	// Consume marks a token used and returns it. ErrNotFound means it is unknown, expired or already used.
+	Consume(ctx context.Context, purpose, tokenHash string) (*AdminToken, error)
+}
+
+type adminUserStore struct {
+	db DBTX
+}
+
+// duplicate maps a unique violation to ErrDuplicate
+func duplicate(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *adminUserStore) CreateFirstOwner(ctx context.Context, u *AdminUser, passwordHash string) (bool, error) {
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO admin_users (first_name, last_name, email, password, role)
+		 SELECT $1, $2, $3, $4, 'owner'
+		 WHERE NOT EXISTS (SELECT 1 FROM admin_users)
+		 RETURNING id, role`,
+		u.FirstName, u.LastName, u.Email, passwordHash,
+	).Scan(&u.ID, &u.Role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, duplicate(err)
+	}
+	return true, nil
+}
+
+func (s *adminUserStore) Create(ctx context.Context, u *AdminUser, passwordHash string) error {
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO admin_users (first_name, last_name, email, password, role)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		u.FirstName, u.LastName, u.Email, passwordHash, u.Role,
+	).Scan(&u.ID)
+	return duplicate(err)
+}
+
+func (s *adminUserStore) Credentials(ctx context.Context, email string) (int, string, string, error) {
+	var id int
+	var role, hash string
+	err := s.db.QueryRow(ctx,
+		`SELECT id, role, password FROM admin_users WHERE email=$1`, email,
+	).Scan(&id, &role, &hash)
+	if err != nil {
+		return 0, "", "", notFound(err)
+	}
+	return id, role, hash, nil
+}
+
+func (s *adminUserStore) Exists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM admin_users WHERE email=$1)`, email,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *adminUserStore) List(ctx context.Context) ([]AdminUser, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, COALESCE(first_name, ''), COALESCE(last_name, ''), email, role
+		 FROM admin_users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []AdminUser
+	for rows.Next() {
+		var a AdminUser
+		if err := rows.Scan(&a.ID, &a.FirstName, &a.LastName, &a.Email, &a.Role); err != nil {
+			return nil, err
+		}
+		admins = append(admins, a)
+	}
+	return admins, rows.Err()
+}
+
+func (s *adminUserStore) SetRole(ctx context.Context, id int, role string) (*AdminUser, error) {
+	var a AdminUser
+	err := s.db.QueryRow(ctx,
+		`UPDATE admin_users SET role=$1
+		 WHERE id=$2
+		   AND ($1 = 'owner' OR role <> 'owner'
+		        OR (SELECT COUNT(*) FROM admin_users WHERE role='owner') > 1)
+		 RETURNING id, COALESCE(first_name, ''), COALESCE(last_name, ''), email, role`,
+		role, id,
+	).Scan(&a.ID, &a.FirstName, &a.LastName, &a.Email, &a.Role)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
+
+func (s *adminUserStore) SetPassword(ctx context.Context, email, passwordHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow(ctx,
+		`UPDATE admin_users SET password=$1 WHERE email=$2 RETURNING id`,
+		passwordHash, email,
+	).Scan(&id)
+	return id, notFound(err)
+}
+
+func (s *adminUserStore) Delete(ctx context.Context, id int) error {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM admin_users
+		 WHERE id=$1
+		   AND (role <> 'owner' OR (SELECT COUNT(*) FROM admin_users WHERE role='owner') > 1)`,
+		id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type adminTokenStore struct {
+	db DBTX
+}
+
+func (s *adminTokenStore) Create(ctx context.Context, t *AdminToken) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO admin_tokens (purpose, email, role, token_hash, created_by, expires_at)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6)`,
+		t.Purpose, t.Email, t.Role, t.TokenHash, t.CreatedBy, t.ExpiresAt,
+	)
+	return err
+}
+
+func (s *adminTokenStore) Consume(ctx context.Context, purpose, tokenHash string) (*AdminToken, error) {
+	t := AdminToken{Purpose: purpose, TokenHash: tokenHash}
+	err := s.db.QueryRow(ctx,
+		`UPDATE admin_tokens SET used_at=NOW()
+		 WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING email, COALESCE(role, ''), created_by, expires_at`,
+		tokenHash, purpose,
+	).Scan(&t.Email, &t.Role, &t.CreatedBy, &t.ExpiresAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
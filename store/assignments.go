@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Assignment is one driver's hold on a dispatch
+type Assignment struct {
+	ID         int        `json:"id"`
+	DispatchID int        `json:"dispatchId"`
+	Driver     Driver     `json:"driver"`
+	Vehicle    Vehicle    `json:"vehicle"`
+	TripID     int        `json:"tripId,omitempty"`
+	AssignedBy string     `json:"assignedBy"`
+	Reason     *string    `json:"reason,omitempty"`
+	AssignedAt time.Time  `json:"assignedAt"`
+	EndedAt    *time.Time `json:"endedAt,omitempty"` // nil for the current holder
+}
+
+// AssignmentStore keeps the assignment history of each dispatch
+type AssignmentStore interface {
+	// Open ends the dispatch's current assignment, if any, and records a as
+	// the new one, filling in ID and AssignedAt. a.Driver.ID and a.Vehicle.ID
+	// must be set.
+	Open(ctx context.Context, a *Assignment) error
+	// ListByDispatch returns a dispatch's assignments, oldest first
+	ListByDispatch(ctx context.Context, dispatchID int) ([]Assignment, error)
+}
+
+type assignmentStore struct {
+	db DBTX
+}
+
+func (s *assignmentStore) Open(ctx context.Context, a *Assignment) error {
+	return s.db.QueryRow(ctx,
+		`WITH ended AS (
+		     UPDATE dispatch_assignments SET ended_at=NOW()
+		     WHERE dispatch_id=$1 AND ended_at IS NULL
+		     RETURNING id
+		 )
+		 -- Reading ended first makes the old row close before the new one
+		 -- is checked against the one-open-assignment index
+		 INSERT INTO dispatch_assignments (dispatch_id, driver_id, vehicle_id, trip_id, assigned_by, reason)
+		 SELECT $1, $2, $3, $4, $5, $6 FROM (SELECT COUNT(*) FROM ended) e
+		 RETURNING id, assigned_at`,
+		a.DispatchID, nullID(a.Driver.ID), nullID(a.Vehicle.ID), nullID(a.TripID), a.AssignedBy, a.Reason,
+	).Scan(&a.ID, &a.AssignedAt)
+}
+
+func (s *assignmentStore) ListByDispatch(ctx context.Context, dispatchID int) ([]Assignment, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT a.id, a.dispatch_id,
+		        COALESCE(a.driver_id, 0), COALESCE(dr.id_number, 0),
+		        COALESCE(dr.first_name, ''), COALESCE(dr.last_name, ''),
+		        COALESCE(a.vehicle_id, 0), COALESCE(v.reg_no, ''),
+		        COALESCE(a.trip_id, 0), a.assigned_by, a.reason, a.assigned_at, a.ended_at
+		 FROM dispatch_assignments a
+		 LEFT JOIN drivers dr ON dr.id = a.driver_id
+		 LEFT JOIN vehicles v ON v.id = a.vehicle_id
+		 WHERE a.dispatch_id=$1
+		 ORDER BY a.assigned_at, a.id`, dispatchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Assignment{}
+	for rows.Next() {
+		var a Assignment
+		if err := rows.Scan(&a.ID, &a.DispatchID,
+			&a.Driver.ID, &a.Driver.IDNumber, &a.Driver.FirstName, &a.Driver.LastName,
+			&a.Vehicle.ID, &a.Vehicle.RegNo,
+			&a.TripID, &a.AssignedBy, &a.Reason, &a.AssignedAt, &a.EndedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
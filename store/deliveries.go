@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery represents proof of completed dispatch by driver
+type Delivery struct {
+	ID         int `json:"id"`
+	DispatchID int `json:"dispatchId"`
+
+	Date   time.Time `json:"date"`
+	TripID int       `json:"tripId"`
+
+	// Proof of delivery, captured by the driver at the door
+	Recipient  string `json:"recipient,omitempty"` // who actually received it
+	Condition  string `json:"condition,omitempty"`
+	Note       string `json:"note,omitempty"`
+	Status     string `json:"status"`               // delivered, partial or failed
+	ReasonCode string `json:"reasonCode,omitempty"` // why a delivery failed or fell short
+	// Quantities, for partial deliveries
+	QuantityExpected  int    `json:"quantityExpected,omitempty"`
+	QuantityDelivered int    `json:"quantityDelivered,omitempty"`
+	PhotoKey          string `json:"-"` // blob store keys; served through the POD endpoints
+	SignatureKey      string `json:"-"`
+	HasPhoto          bool   `json:"hasPhoto"`
+	HasSignature      bool   `json:"hasSignature"`
+}
+
+// DeliveryStore persists deliveries
+type DeliveryStore interface {
+	// Create inserts d and fills in ID, Date and Status
+	Create(ctx context.Context, d *Delivery) error
+	List(ctx context.Context) ([]Delivery, error)
+	ListByDispatch(ctx context.Context, dispatchID int) ([]Delivery, error)
+	// ListByDriver returns deliveries recorded on the driver's trips
+	ListByDriver(ctx context.Context, driverID int) ([]Delivery, error)
+	Get(ctx context.Context, id int) (*Delivery, error)
+	// AttachPOD adds proof of delivery to an existing delivery. Empty fields
+	// keep their current value. It returns the updated delivery.
+	AttachPOD(ctx context.Context, id int, pod Delivery) (*Delivery, error)
+	// Delete removes a delivery, or returns ErrNotFound
+	Delete(ctx context.Context, id int) error
+}
+
+type deliveryStore struct {
+	db DBTX
+}
+
+const deliverySelect = `
+	SELECT id, COALESCE(dispatch_id, 0), COALESCE(trip_id, 0), date,
+	       COALESCE(recipient, ''), COALESCE(condition, ''), COALESCE(delivery_note, ''),
+	       COALESCE(status, ''), COALESCE(photo_key, ''), COALESCE(signature_key, ''),
+	       COALESCE(reason_code, ''), COALESCE(quantity_expected, 0), COALESCE(quantity_delivered, 0)
+	FROM deliveries`
+
+func scanDelivery(row interface{ Scan(...any) error }) (*Delivery, error) {
+	var d Delivery
+	if err := row.Scan(&d.ID, &d.DispatchID, &d.TripID, &d.Date,
+		&d.Recipient, &d.Condition, &d.Note,
+		&d.Status, &d.PhotoKey, &d.SignatureKey,
+		&d.ReasonCode, &d.QuantityExpected, &d.QuantityDelivered); err != nil {
+		return nil, err
+	}
+	d.HasPhoto = d.PhotoKey != ""
+	d.HasSignature = d.SignatureKey != ""
+	return &d, nil
+}
+
+func (s *deliveryStore) query(ctx context.Context, where string, args ...any) ([]Delivery, error) {
+	rows, err := s.db.Query(ctx, deliverySelect+" "+where+" ORDER BY date DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *d)
+	}
+	return list, rows.Err()
+}
+
+func (s *deliveryStore) Create(ctx context.Context, d *Delivery) error {
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO deliveries (dispatch_id, trip_id, date, recipient, condition, delivery_note,
+		                         status, photo_key, signature_key,
+		                         reason_code, quantity_expected, quantity_delivered)
+		 VALUES ($1, $2, NOW(), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''),
+		         COALESCE(NULLIF($6, ''), 'delivered'), NULLIF($7, ''), NULLIF($8, ''),
+		         NULLIF($9, ''), NULLIF($10, 0), NULLIF($11, 0))
+		 RETURNING id, date, status`,
+		nullID(d.DispatchID), nullID(d.TripID), d.Recipient, d.Condition, d.Note,
+		d.Status, d.PhotoKey, d.SignatureKey,
+		d.ReasonCode, d.QuantityExpected, d.QuantityDelivered,
+	).Scan(&d.ID, &d.Date, &d.Status)
+	d.HasPhoto = d.PhotoKey != ""
+	d.HasSignature = d.SignatureKey != ""
+	return err
+}
+
+func (s *deliveryStore) List(ctx context.Context) ([]Delivery, error) {
+	return s.query(ctx, "")
+}
+
+func (s *deliveryStore) ListByDispatch(ctx context.Context, dispatchID int) ([]Delivery, error) {
+	return s.query(ctx, "WHERE dispatch_id=$1", dispatchID)
+}
+
+func (s *deliveryStore) ListByDriver(ctx context.Context, driverID int) ([]Delivery, error) {
+	return s.query(ctx, "WHERE trip_id IN (SELECT id FROM trips WHERE driver_id=$1)", driverID)
+}
+
+func (s *deliveryStore) Get(ctx context.Context, id int) (*Delivery, error) {
+	d, err := scanDelivery(s.db.QueryRow(ctx, deliverySelect+" WHERE id=$1", id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return d, nil
+}
+
+func (s *deliveryStore) AttachPOD(ctx context.Context, id int, pod Delivery) (*Delivery, error) {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE deliveries
+		 SET recipient=COALESCE(NULLIF($1, ''), recipient),
+		     condition=COALESCE(NULLIF($2, ''), condition),
+		     delivery_note=COALESCE(NULLIF($3, ''), delivery_note),
+		     photo_key=COALESCE(NULLIF($4, ''), photo_key),
+		     signature_key=COALESCE(NULLIF($5, ''), signature_key)
+		 WHERE id=$6`,
+		pod.Recipient, pod.Condition, pod.Note, pod.PhotoKey, pod.SignatureKey, id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *deliveryStore) Delete(ctx context.Context, id int) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM deliveries WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
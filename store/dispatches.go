@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Dispatch struct {
+	ID        int    `json:"id"`
+	Recipient string `json:"recipient"` // ✅ corrected
+	Phone     string `json:"phone"`     // recipient's number in E.164, texted the OTP
+
+	Location string    `json:"location"`
+	Driver   Driver    `json:"driver"`
+	Vehicle  Vehicle   `json:"vehicle"`
+	Invoice  int       `json:"invoice"`
+	Date     time.Time `json:"date"`
+	Verified bool      `json:"verified"`
+	Status   string    `json:"status"` // pending, delivered, partial or failed
+
+	// Destination is where the trip is considered arrived, if known
+	Destination *Geofence `json:"destination,omitempty"`
+}
+
+// Geofence is a circle around a dispatch destination
+type Geofence struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius    float64 `json:"radius"` // metres
+}
+
+// Center returns the geofence's centre point
+func (g Geofence) Center() Location {
+	return Location{Latitude: g.Latitude, Longitude: g.Longitude}
+}
+
+// DispatchStore persists dispatches
+type DispatchStore interface {
+	// Create inserts d for the resolved driver and vehicle ids and fills in ID, Date, Verified and Status
+	Create(ctx context.Context, d *Dispatch, driverID, vehicleID int) error
+	List(ctx context.Context) ([]Dispatch, error)
+	ListByStatus(ctx context.Context, status string) ([]Dispatch, error)
+	ListByDriver(ctx context.Context, driverID int) ([]Dispatch, error)
+	Get(ctx context.Context, id int) (*Dispatch, error)
+	// Update changes the delivery details, writing Destination as given (nil
+	// clears the geofence); the driver and vehicle only change through Reassign
+	Update(ctx context.Context, d *Dispatch) error
+	// Reassign hands the dispatch to another driver and vehicle
+	Reassign(ctx context.Context, id, driverID, vehicleID int) error
+	Delete(ctx context.Context, id int) error
+	// Phone returns the recipient phone used for OTP
+	Phone(ctx context.Context, id int) (string, error)
+	// MarkVerified records a successful OTP check and marks the dispatch delivered
+	MarkVerified(ctx context.Context, id int) error
+	// SetStatus records a delivery outcome, or puts a rescheduled dispatch back to pending
+	SetStatus(ctx context.Context, id int, status string) error
+	// Lock holds the dispatch row until the transaction ends, or returns ErrNotFound
+	Lock(ctx context.Context, id int) error
+}
+
+type dispatchStore struct {
+	db DBTX
+}
+
+// dispatchSelect joins in the driver and vehicle shown on the dashboard
+const dispatchSelect = `
+	SELECT d.id, d.recipient, COALESCE(d.phone, ''), d.location, COALESCE(d.invoice, 0), d.date, d.verified, d.status,
+	       d.dest_latitude, d.dest_longitude, d.geofence_radius,
+	       COALESCE(d.driver_id, 0), dr.id_number, dr.first_name || ' ' || dr.last_name AS driver_name,
+	       COALESCE(d.vehicle_id, 0), v.reg_no
+	FROM dispatches d
+	LEFT JOIN drivers dr ON d.driver_id = dr.id
+	LEFT JOIN vehicles v ON d.vehicle_id = v.id`
+
+func scanDispatch(row interface{ Scan(...any) error }) (*Dispatch, error) {
+	var d Dispatch
+	var driverIDNumber sql.NullInt64
+	var driverName sql.NullString
+	var vehicleReg sql.NullString
+	var destLat, destLon, radius sql.NullFloat64
+
+	if err := row.Scan(&d.ID, &d.Recipient, &d.Phone, &d.Location, &d.Invoice, &d.Date, &d.Verified, &d.Status,
+		&destLat, &destLon, &radius,
+		&d.Driver.ID, &driverIDNumber, &driverName,
+		&d.Vehicle.ID, &vehicleReg); err != nil {
+		return nil, err
+	}
+
+	if destLat.Valid && destLon.Valid {
+		d.Destination = &Geofence{Latitude: destLat.Float64, Longitude: destLon.Float64, Radius: radius.Float64}
+	}
+
+	if driverIDNumber.Valid {
+		d.Driver.IDNumber = int(driverIDNumber.Int64)
+	}
+	if driverName.Valid {
+		d.Driver.FirstName = driverName.String
+	}
+	if vehicleReg.Valid {
+		d.Vehicle.RegNo = vehicleReg.String
+	}
+	return &d, nil
+}
+
+func (s *dispatchStore) query(ctx context.Context, where string, args ...any) ([]Dispatch, error) {
+	rows, err := s.db.Query(ctx, dispatchSelect+" "+where+" ORDER BY d.date DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dispatches []Dispatch
+	for rows.Next() {
+		d, err := scanDispatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		dispatches = append(dispatches, *d)
+	}
+	return dispatches, rows.Err()
+}
+
+// geofenceArgs splits an optional geofence into nullable columns
+func geofenceArgs(g *Geofence) (lat, lon, radius *float64) {
+	if g == nil {
+		return nil, nil, nil
+	}
+	return &g.Latitude, &g.Longitude, &g.Radius
+}
+
+func (s *dispatchStore) Create(ctx context.Context, d *Dispatch, driverID, vehicleID int) error {
+	lat, lon, radius := geofenceArgs(d.Destination)
+	return s.db.QueryRow(ctx,
+		`INSERT INTO dispatches (recipient, phone, location, driver_id, vehicle_id, invoice, verified, date,
+		                         dest_latitude, dest_longitude, geofence_radius)
+		 VALUES ($1, $2, $3, $4, $5, $6, FALSE, NOW(), $7, $8, $9)
+		 RETURNING id, date, verified, status`,
+		d.Recipient, d.Phone, d.Location, driverID, vehicleID, d.Invoice, lat, lon, radius,
+	).Scan(&d.ID, &d.Date, &d.Verified, &d.Status)
+}
+
+func (s *dispatchStore) List(ctx context.Context) ([]Dispatch, error) {
+	return s.query(ctx, "")
+}
+
+func (s *dispatchStore) ListByStatus(ctx context.Context, status string) ([]Dispatch, error) {
+	return s.query(ctx, "WHERE d.status=$1", status)
+}
+
+func (s *dispatchStore) ListByDriver(ctx context.Context, driverID int) ([]Dispatch, error) {
+	return s.query(ctx, "WHERE d.driver_id=$1", driverID)
+}
+
+func (s *dispatchStore) Get(ctx context.Context, id int) (*Dispatch, error) {
+	d, err := scanDispatch(s.db.QueryRow(ctx, dispatchSelect+" WHERE d.id=$1", id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return d, nil
+}
+
+func (s *dispatchStore) Update(ctx context.Context, d *Dispatch) error {
+	lat, lon, radius := geofenceArgs(d.Destination)
+	_, err := s.db.Exec(ctx,
+		`UPDATE dispatches
+		 SET recipient=$1, phone=$2, location=$3, invoice=$4,
+		     dest_latitude=$5, dest_longitude=$6, geofence_radius=$7
+		 WHERE id=$8`,
+		d.Recipient, d.Phone, d.Location, d.Invoice, lat, lon, radius, d.ID)
+	return err
+}
+
+func (s *dispatchStore) Reassign(ctx context.Context, id, driverID, vehicleID int) error {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE dispatches SET driver_id=$1, vehicle_id=$2 WHERE id=$3`,
+		driverID, vehicleID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *dispatchStore) Delete(ctx context.Context, id int) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM dispatches WHERE id=$1`, id)
+	return err
+}
+
+func (s *dispatchStore) Phone(ctx context.Context, id int) (string, error) {
+	var phone string
+	err := s.db.QueryRow(ctx, `SELECT COALESCE(phone, '') FROM dispatches WHERE id=$1`, id).Scan(&phone)
+	return phone, notFound(err)
+}
+
+func (s *dispatchStore) MarkVerified(ctx context.Context, id int) error {
+	_, err := s.db.Exec(ctx, `UPDATE dispatches SET verified=TRUE, status='delivered' WHERE id=$1`, id)
+	return err
+}
+
+func (s *dispatchStore) SetStatus(ctx context.Context, id int, status string) error {
+	tag, err := s.db.Exec(ctx, `UPDATE dispatches SET status=$1 WHERE id=$2`, status, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *dispatchStore) Lock(ctx context.Context, id int) error {
+	var locked int
+	err := s.db.QueryRow(ctx, `SELECT id FROM dispatches WHERE id=$1 FOR UPDATE`, id).Scan(&locked)
+	return notFound(err)
+}
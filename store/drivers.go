@@ -0,0 +1,126 @@
+package store
+
+import "context"
+
+type Driver struct {
+	ID          int    `json:"id"` // ✅ new primary key field
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	IDNumber    int    `json:"idNumber"`
+	Password    string `json:"password"`
+	PhoneNumber *int64 `json:"phoneNumber,omitempty"`
+}
+
+// Location is a latitude/longitude pair
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// DriverStore persists drivers
+type DriverStore interface {
+	Create(ctx context.Context, d *Driver, passwordHash string) error
+	List(ctx context.Context) ([]Driver, error)
+	Get(ctx context.Context, id int) (*Driver, error)
+	// IDByIDNumber resolves a national ID number to drivers.id
+	IDByIDNumber(ctx context.Context, idNumber int) (int, error)
+	// Credentials returns drivers.id and the stored password for a login
+	Credentials(ctx context.Context, idNumber int) (int, string, error)
+	// UpgradePassword replaces a legacy plaintext password with a hash
+	UpgradePassword(ctx context.Context, id int, passwordHash string) error
+	CountPlaintextPasswords(ctx context.Context) (int, error)
+	UpdateLocation(ctx context.Context, id int, loc Location) error
+	GetLocation(ctx context.Context, id int) (*Location, error)
+}
+
+type driverStore struct {
+	db DBTX
+}
+
+// bcrypt hashes start with $2a$, $2b$ or $2y$
+const plaintextPassword = "password NOT LIKE '$2_$%'"
+
+func (s *driverStore) Create(ctx context.Context, d *Driver, passwordHash string) error {
+	return s.db.QueryRow(ctx,
+		`INSERT INTO drivers (first_name, last_name, id_number, password, phone_number)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		d.FirstName, d.LastName, d.IDNumber, passwordHash, d.PhoneNumber,
+	).Scan(&d.ID)
+}
+
+func (s *driverStore) List(ctx context.Context) ([]Driver, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, id_number, COALESCE(first_name, ''), COALESCE(last_name, ''), phone_number FROM drivers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drivers []Driver
+	for rows.Next() {
+		var d Driver
+		if err := rows.Scan(&d.ID, &d.IDNumber, &d.FirstName, &d.LastName, &d.PhoneNumber); err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, d)
+	}
+	return drivers, rows.Err()
+}
+
+func (s *driverStore) Get(ctx context.Context, id int) (*Driver, error) {
+	var d Driver
+	err := s.db.QueryRow(ctx,
+		`SELECT id, COALESCE(first_name, ''), COALESCE(last_name, ''), id_number, phone_number
+		 FROM drivers WHERE id=$1`, id,
+	).Scan(&d.ID, &d.FirstName, &d.LastName, &d.IDNumber, &d.PhoneNumber)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &d, nil
+}
+
+func (s *driverStore) IDByIDNumber(ctx context.Context, idNumber int) (int, error) {
+	var id int
+	err := s.db.QueryRow(ctx, `SELECT id FROM drivers WHERE id_number=$1`, idNumber).Scan(&id)
+	return id, notFound(err)
+}
+
+func (s *driverStore) Credentials(ctx context.Context, idNumber int) (int, string, error) {
+	var id int
+	var password string
+	err := s.db.QueryRow(ctx,
+		`SELECT id, password FROM drivers WHERE id_number=$1`, idNumber,
+	).Scan(&id, &password)
+	return id, password, notFound(err)
+}
+
+func (s *driverStore) UpgradePassword(ctx context.Context, id int, passwordHash string) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE drivers SET password=$1 WHERE id=$2 AND `+plaintextPassword,
+		passwordHash, id)
+	return err
+}
+
+func (s *driverStore) CountPlaintextPasswords(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM drivers WHERE `+plaintextPassword).Scan(&n)
+	return n, err
+}
+
+func (s *driverStore) UpdateLocation(ctx context.Context, id int, loc Location) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE drivers SET latitude=$1, longitude=$2 WHERE id=$3`,
+		loc.Latitude, loc.Longitude, id)
+	return err
+}
+
+func (s *driverStore) GetLocation(ctx context.Context, id int) (*Location, error) {
+	var loc Location
+	err := s.db.QueryRow(ctx,
+		`SELECT latitude, longitude FROM drivers WHERE id=$1`, id,
+	).Scan(&loc.Latitude, &loc.Longitude)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &loc, nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// OTP log actions
+const (
+	OTPSend   = "send"
+	OTPVerify = "verify"
+)
+
+// OTP log outcomes
+const (
+	OTPSending     = "sending" // reserved while the provider is called; becomes sent or send_failed
+	OTPSent        = "sent"
+	OTPSendFailed  = "send_failed"
+	OTPRateLimited = "rate_limited"
+	OTPApproved    = "approved"
+	OTPRejected    = "rejected"
+	OTPExpired     = "expired"
+	OTPLocked      = "locked"
+	OTPError       = "error"
+)
+
+// OTPAttempt is one send or verify attempt for a dispatch
+type OTPAttempt struct {
+	ID         int        `json:"id"`
+	DispatchID int        `json:"dispatchId"`
+	Action     string     `json:"action"`
+	Outcome    string     `json:"outcome"`
+	Phone      string     `json:"phone,omitempty"`
+	Actor      string     `json:"actor"`
+	Detail     *string    `json:"detail,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// OTPLogStore records OTP attempts per dispatch
+type OTPLogStore interface {
+	// Record inserts a and fills in ID and CreatedAt
+	Record(ctx context.Context, a *OTPAttempt) error
+	// Finish settles a reserved (sending) attempt with its outcome and detail
+	Finish(ctx context.Context, id int, outcome string, detail *string) error
+	// ListByDispatch returns a dispatch's attempts, oldest first
+	ListByDispatch(ctx context.Context, dispatchID int) ([]OTPAttempt, error)
+	// LastSent returns the most recent successful or in-flight send, or ErrNotFound
+	LastSent(ctx context.Context, dispatchID int) (*OTPAttempt, error)
+	// CountSent counts successful and in-flight sends since the given time
+	CountSent(ctx context.Context, dispatchID int, since time.Time) (int, error)
+	// FailedVerifies counts wrong codes entered since the last successful send
+	FailedVerifies(ctx context.Context, dispatchID int) (int, error)
+}
+
+type otpLogStore struct {
+	db DBTX
+}
+
+const otpLogSelect = `
+	SELECT id, dispatch_id, action, outcome, COALESCE(phone, ''), actor, detail, expires_at, created_at
+	FROM dispatch_otp_log`
+
+func scanOTPAttempt(row interface{ Scan(...any) error }) (*OTPAttempt, error) {
+	var a OTPAttempt
+	if err := row.Scan(&a.ID, &a.DispatchID, &a.Action, &a.Outcome, &a.Phone,
+		&a.Actor, &a.Detail, &a.ExpiresAt, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *otpLogStore) Record(ctx context.Context, a *OTPAttempt) error {
+	return s.db.QueryRow(ctx,
+		`INSERT INTO dispatch_otp_log (dispatch_id, action, outcome, phone, actor, detail, expires_at)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+		 RETURNING id, created_at`,
+		a.DispatchID, a.Action, a.Outcome, a.Phone, a.Actor, a.Detail, a.ExpiresAt,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+func (s *otpLogStore) Finish(ctx context.Context, id int, outcome string, detail *string) error {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE dispatch_otp_log SET outcome=$1, detail=$2 WHERE id=$3 AND outcome='sending'`,
+		outcome, detail, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *otpLogStore) ListByDispatch(ctx context.Context, dispatchID int) ([]OTPAttempt, error) {
+	rows, err := s.db.Query(ctx, otpLogSelect+" WHERE dispatch_id=$1 ORDER BY created_at, id", dispatchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []OTPAttempt{}
+	for rows.Next() {
+		a, err := scanOTPAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *a)
+	}
+	return res, rows.Err()
+}
+
+func (s *otpLogStore) LastSent(ctx context.Context, dispatchID int) (*OTPAttempt, error) {
+	a, err := scanOTPAttempt(s.db.QueryRow(ctx,
+		otpLogSelect+" WHERE dispatch_id=$1 AND action='send' AND outcome IN ('sent', 'sending') ORDER BY id DESC LIMIT 1",
+		dispatchID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return a, nil
+}
+
+func (s *otpLogStore) CountSent(ctx context.Context, dispatchID int, since time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM dispatch_otp_log
+		 WHERE dispatch_id=$1 AND action='send' AND outcome IN ('sent', 'sending') AND created_at >= $2`,
+		dispatchID, since,
+	).Scan(&n)
+	return n, err
+}
+
+func (s *otpLogStore) FailedVerifies(ctx context.Context, dispatchID int) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM dispatch_otp_log
+		 WHERE dispatch_id=$1 AND action='verify' AND outcome='rejected'
+		   AND id > COALESCE((SELECT MAX(id) FROM dispatch_otp_log
+		                      WHERE dispatch_id=$1 AND action='send' AND outcome='sent'), 0)`,
+		dispatchID,
+	).Scan(&n)
+	return n, err
+}
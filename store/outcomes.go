@@ -0,0 +1,54 @@
+package store
+
+// Dispatch statuses. A dispatch is pending until a delivery outcome is
+// recorded; failed and partial dispatches wait to be rescheduled.
+const (
+	DispatchPending   = "pending"
+	DispatchDelivered = "delivered"
+	DispatchPartial   = "partial"
+	DispatchFailed    = "failed"
+)
+
+// Delivery outcomes, stored in deliveries.status
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryPartial   = "partial"
+	DeliveryFailed    = "failed"
+)
+
+// Reason codes for failed and partial deliveries
+const (
+	ReasonRecipientAbsent = "recipient_absent"
+	ReasonRefused         = "refused"
+	ReasonDamaged         = "damaged"
+	ReasonWrongAddress    = "wrong_address"
+	ReasonInaccessible    = "inaccessible"
+	ReasonOther           = "other" // needs a note
+)
+
+// ReasonCodes lists the accepted reason codes, for error messages and clients
+var ReasonCodes = []string{
+	ReasonRecipientAbsent, ReasonRefused, ReasonDamaged,
+	ReasonWrongAddress, ReasonInaccessible, ReasonOther,
+}
+
+// ValidReasonCode reports whether code is a known reason code
+func ValidReasonCode(code string) bool {
+	for _, c := range ReasonCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsReschedule reports whether a dispatch in status is waiting for a new trip
+func NeedsReschedule(status string) bool {
+	return status == DispatchFailed || status == DispatchPartial
+}
+
+// awaitingReschedule matches the latest trip of a dispatch that failed or was
+// partly delivered, or that is still pending after that trip was cancelled, so
+// it stays visible until the dispatch is rescheduled or reassigned
+const awaitingReschedule = `((ds.status IN ('failed', 'partial') OR (ds.status = 'pending' AND t.status = 'cancelled'))
+	AND t.id = (SELECT MAX(t2.id) FROM trips t2 WHERE t2.dispatch_id = t.dispatch_id))`
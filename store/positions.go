@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Position is one GPS fix reported by a driver
+type Position struct {
+	TripID     int       `json:"tripId,omitempty"`
+	DriverID   int       `json:"driverId,omitempty"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Accuracy   *float64  `json:"accuracy,omitempty"` // metres
+	Speed      *float64  `json:"speed,omitempty"`    // metres per second
+	Heading    *float64  `json:"heading,omitempty"`  // degrees clockwise from north
+	RecordedAt time.Time `json:"recordedAt"`         // device time of the fix
+}
+
+// Location returns the fix's latitude/longitude
+func (p Position) Location() Location {
+	return Location{Latitude: p.Latitude, Longitude: p.Longitude}
+}
+
+// PositionStore keeps the breadcrumb history behind trips and drivers
+type PositionStore interface {
+	Append(ctx context.Context, p *Position) error
+	// AppendBatch bulk-inserts fixes with COPY, skipping any already stored for
+	// the same trip (or driver) and device time. It returns how many were new.
+	// It uses a temporary table, so it must run inside Store.InTx.
+	AppendBatch(ctx context.Context, ps []Position) (int64, error)
+	// Latest returns the trip's most recent fix by device time
+	Latest(ctx context.Context, tripID int) (*Position, error)
+	// ListByTrip returns a trip's fixes ordered by device time, optionally within [from, to]
+	ListByTrip(ctx context.Context, tripID int, from, to *time.Time) ([]Position, error)
+}
+
+type positionStore struct {
+	db DBTX
+}
+
+func (s *positionStore) Append(ctx context.Context, p *Position) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO trip_positions (trip_id, driver_id, latitude, longitude, accuracy, speed, heading, recorded_at)
+		 VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6, $7, $8)`,
+		p.TripID, p.DriverID, p.Latitude, p.Longitude, p.Accuracy, p.Speed, p.Heading, p.RecordedAt)
+	return err
+}
+
+func (s *positionStore) AppendBatch(ctx context.Context, ps []Position) (int64, error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.db.Exec(ctx,
+		`CREATE TEMP TABLE trip_positions_batch (
+		     trip_id INTEGER, driver_id INTEGER,
+		     latitude DOUBLE PRECISION, longitude DOUBLE PRECISION,
+		     accuracy DOUBLE PRECISION, speed DOUBLE PRECISION, heading DOUBLE PRECISION,
+		     recorded_at TIMESTAMP
+		 ) ON COMMIT DROP`); err != nil {
+		return 0, err
+	}
+
+	columns := []string{"trip_id", "driver_id", "latitude", "longitude", "accuracy", "speed", "heading", "recorded_at"}
+	_, err := s.db.CopyFrom(ctx, pgx.Identifier{"trip_positions_batch"}, columns,
+		pgx.CopyFromSlice(len(ps), func(i int) ([]any, error) {
+			p := ps[i]
+			return []any{nullID(p.TripID), nullID(p.DriverID), p.Latitude, p.Longitude,
+				p.Accuracy, p.Speed, p.Heading, p.RecordedAt}, nil
+		}))
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO trip_positions (trip_id, driver_id, latitude, longitude, accuracy, speed, heading, recorded_at)
+		 SELECT b.trip_id, b.driver_id, b.latitude, b.longitude, b.accuracy, b.speed, b.heading, b.recorded_at
+		 FROM trip_positions_batch b
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM trip_positions p
+		     WHERE p.recorded_at = b.recorded_at
+		       AND p.trip_id IS NOT DISTINCT FROM b.trip_id
+		       AND p.driver_id IS NOT DISTINCT FROM b.driver_id)
+		 ORDER BY b.recorded_at`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *positionStore) Latest(ctx context.Context, tripID int) (*Position, error) {
+	var p Position
+	err := s.db.QueryRow(ctx,
+		`SELECT COALESCE(trip_id, 0), COALESCE(driver_id, 0), latitude, longitude, accuracy, speed, heading, recorded_at
+		 FROM trip_positions WHERE trip_id=$1
+		 ORDER BY recorded_at DESC, id DESC LIMIT 1`, tripID,
+	).Scan(&p.TripID, &p.DriverID, &p.Latitude, &p.Longitude, &p.Accuracy, &p.Speed, &p.Heading, &p.RecordedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+// nullID stores 0 as NULL
+func nullID(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func (s *positionStore) ListByTrip(ctx context.Context, tripID int, from, to *time.Time) ([]Position, error) {
+	q := `SELECT COALESCE(trip_id, 0), COALESCE(driver_id, 0), latitude, longitude, accuracy, speed, heading, recorded_at
+	      FROM trip_positions WHERE trip_id=$1`
+	args := []any{tripID}
+	if from != nil {
+		args = append(args, *from)
+		q += " AND recorded_at >= $" + strconv.Itoa(len(args))
+	}
+	if to != nil {
+		args = append(args, *to)
+		q += " AND recorded_at <= $" + strconv.Itoa(len(args))
+	}
+	q += " ORDER BY recorded_at, id"
+
+	rows, err := s.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Position
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.TripID, &p.DriverID, &p.Latitude, &p.Longitude,
+			&p.Accuracy, &p.Speed, &p.Heading, &p.RecordedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
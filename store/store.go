@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrNotFound is returned when a lookup matches no row
+var ErrNotFound = errors.New("not found")
+
+// DBTX is satisfied by both *pgxpool.Pool and pgx.Tx, so every repository
+// can run inside or outside a transaction
+type DBTX interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// Repo is what handlers depend on: the typed repositories and a way to run
+// them in one transaction. *Store is the Postgres implementation; tests use
+// the in-memory one in store/storetest.
+type Repo interface {
+	Drivers() DriverStore
+	Vehicles() VehicleStore
+	Dispatches() DispatchStore
+	Trips() TripStore
+	Deliveries() DeliveryStore
+	Positions() PositionStore
+	Assignments() AssignmentStore
+	OTPLog() OTPLogStore
+	AdminUsers() AdminUserStore
+	AdminTokens() AdminTokenStore
+
+	// InTx runs fn against repositories bound to a single transaction.
+	// The transaction commits if fn returns nil and rolls back otherwise.
+	InTx(ctx context.Context, fn func(tx Repo) error) error
+}
+
+// Store is the Postgres-backed Repo
+type Store struct {
+	pool *pgxpool.Pool
+	db   DBTX
+
+	drivers     DriverStore
+	vehicles    VehicleStore
+	dispatches  DispatchStore
+	trips       TripStore
+	deliveries  DeliveryStore
+	positions   PositionStore
+	assignments AssignmentStore
+	otpLog      OTPLogStore
+	adminUsers  AdminUserStore
+	adminTokens AdminTokenStore
+}
+
+var _ Repo = (*Store)(nil)
+
+// NewPool opens the single connection pool shared by the whole server
+func NewPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Neon's pooler doesn't keep prepared statements across transactions
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+
+	return pgxpool.NewWithConfig(ctx, config)
+}
+
+// New builds the Postgres-backed repositories on top of pool
+func New(pool *pgxpool.Pool) *Store {
+	s := newStore(pool)
+	s.pool = pool
+	return s
+}
+
+func newStore(db DBTX) *Store {
+	return &Store{
+		db:          db,
+		drivers:     &driverStore{db: db},
+		vehicles:    &vehicleStore{db: db},
+		dispatches:  &dispatchStore{db: db},
+		trips:       &tripStore{db: db},
+		deliveries:  &deliveryStore{db: db},
+		positions:   &positionStore{db: db},
+		assignments: &assignmentStore{db: db},
+		otpLog:      &otpLogStore{db: db},
+		adminUsers:  &adminUserStore{db: db},
+		adminTokens: &adminTokenStore{db: db},
+	}
+}
+
+func (s *Store) Drivers() DriverStore         { return s.drivers }
+func (s *Store) Vehicles() VehicleStore       { return s.vehicles }
+func (s *Store) Dispatches() DispatchStore    { return s.dispatches }
+func (s *Store) Trips() TripStore             { return s.trips }
+func (s *Store) Deliveries() DeliveryStore    { return s.deliveries }
+func (s *Store) Positions() PositionStore     { return s.positions }
+func (s *Store) Assignments() AssignmentStore { return s.assignments }
+func (s *Store) OTPLog() OTPLogStore          { return s.otpLog }
+func (s *Store) AdminUsers() AdminUserStore   { return s.adminUsers }
+func (s *Store) AdminTokens() AdminTokenStore { return s.adminTokens }
+
+// Pool returns the underlying connection pool
+func (s *Store) Pool() *pgxpool.Pool {
+	return s.pool
+}
+
+// InTx runs fn against repositories bound to a single transaction.
+// The transaction commits if fn returns nil and rolls back otherwise;
+// calling InTx on a transaction's Store nests it as a savepoint.
+func (s *Store) InTx(ctx context.Context, fn func(tx Repo) error) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		return fn(newStore(tx))
+	})
+}
+
+// notFound maps pgx.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package storetest
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+type adminUserStore struct{ s *Store }
+
+// adminByEmail returns the admin with email, if any
+func (d *data) adminByEmail(email string) (adminRow, bool) {
+	for _, a := range d.admins {
+		if a.Email == email {
+			return a, true
+		}
+	}
+	return adminRow{}, false
+}
+
+// owners counts admins with the owner role
+func (d *data) owners() int {
+	n := 0
+	for _, a := range d.admins {
+		if a.Role == "owner" {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *adminUserStore) CreateFirstOwner(ctx context.Context, u *store.AdminUser, passwordHash string) (bool, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if len(d.admins) > 0 {
+		return false, nil
+	}
+	u.ID = d.nextID("admin_users")
+	u.Role = "owner"
+	d.admins[u.ID] = adminRow{AdminUser: *u, hash: passwordHash}
+	return true, nil
+}
+
+func (r *adminUserStore) Create(ctx context.Context, u *store.AdminUser, passwordHash string) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if _, ok := d.adminByEmail(u.Email); ok {
+		return store.ErrDuplicate
+	}
+	u.ID = d.nextID("admin_users")
+	d.admins[u.ID] = adminRow{AdminUser: *u, hash: passwordHash}
+	return nil
+}
+
+func (r *adminUserStore) Credentials(ctx context.Context, email string) (int, string, string, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	a, ok := d.adminByEmail(email)
+	if !ok {
+		return 0, "", "", store.ErrNotFound
+	}
+	return a.ID, a.Role, a.hash, nil
+}
+
+func (r *adminUserStore) Exists(ctx context.Context, email string) (bool, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	_, ok := d.adminByEmail(email)
+	return ok, nil
+}
+
+func (r *adminUserStore) List(ctx context.Context) ([]store.AdminUser, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var admins []store.AdminUser
+	for _, id := range slices.Sorted(maps.Keys(d.admins)) {
+		admins = append(admins, d.admins[id].AdminUser)
+	}
+	return admins, nil
+}
+
+func (r *adminUserStore) SetRole(ctx context.Context, id int, role string) (*store.AdminUser, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	a, ok := d.admins[id]
+	if !ok || (role != "owner" && a.Role == "owner" && d.owners() <= 1) {
+		return nil, store.ErrNotFound
+	}
+	a.Role = role
+	d.admins[id] = a
+	return &a.AdminUser, nil
+}
+
+func (r *adminUserStore) SetPassword(ctx context.Context, email, passwordHash string) (int, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	a, ok := d.adminByEmail(email)
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	a.hash = passwordHash
+	d.admins[a.ID] = a
+	return a.ID, nil
+}
+
+func (r *adminUserStore) Delete(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	a, ok := d.admins[id]
+	if !ok || (a.Role == "owner" && d.owners() <= 1) {
+		return store.ErrNotFound
+	}
+	delete(d.admins, id)
+	return nil
+}
+
+type adminTokenStore struct{ s *Store }
+
+func (r *adminTokenStore) Create(ctx context.Context, t *store.AdminToken) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for _, existing := range d.tokens {
+		if existing.TokenHash == t.TokenHash {
+			return store.ErrDuplicate
+		}
+	}
+	d.tokens = append(d.tokens, tokenRow{AdminToken: *t})
+	return nil
+}
+
+func (r *adminTokenStore) Consume(ctx context.Context, purpose, tokenHash string) (*store.AdminToken, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for i, t := range d.tokens {
+		if t.TokenHash == tokenHash && t.Purpose == purpose && !t.used && t.ExpiresAt.After(now()) {
+			d.tokens[i].used = true
+			return &t.AdminToken, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
@@ -0,0 +1,244 @@
+package storetest_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mangochops/coninx_backend/migrations"
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+// The same checks run against the fake and, when TEST_DATABASE_URL points at
+// a scratch Postgres database, against the real store, so the fake can't
+// quietly drift from the SQL it imitates. Every check runs inside InTx and is
+// rolled back.
+
+var errRollback = errors.New("rollback")
+
+// repos returns the stores to check, skipping Postgres if no database is configured
+func repos(t *testing.T) map[string]store.Repo {
+	t.Helper()
+	repos := map[string]store.Repo{"fake": storetest.New()}
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Log("TEST_DATABASE_URL not set; checking the fake only")
+		return repos
+	}
+	ctx := context.Background()
+	pool, err := store.NewPool(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := migrations.Up(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	repos["postgres"] = store.New(pool)
+	return repos
+}
+
+// inRolledBackTx runs fn for each store inside a transaction that is always undone
+func inRolledBackTx(t *testing.T, fn func(t *testing.T, tx store.Repo)) {
+	for name, repo := range repos(t) {
+		t.Run(name, func(t *testing.T) {
+			err := repo.InTx(context.Background(), func(tx store.Repo) error {
+				fn(t, tx)
+				return errRollback
+			})
+			if !errors.Is(err, errRollback) {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// seed creates a driver, vehicle and dispatch with values unlikely to clash
+// with rows already in a shared database
+func seed(t *testing.T, tx store.Repo) (store.Driver, store.Vehicle, store.Dispatch) {
+	t.Helper()
+	ctx := context.Background()
+	n := int(time.Now().UnixNano() % 1e9)
+
+	d := store.Driver{FirstName: "Test", LastName: "Driver", IDNumber: 900000000 + n%100000000}
+	if err := tx.Drivers().Create(ctx, &d, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	v := store.Vehicle{Type: "van", RegNo: "TEST " + strconv.Itoa(n)}
+	if err := tx.Vehicles().Create(ctx, &v); err != nil {
+		t.Fatal(err)
+	}
+	ds := store.Dispatch{Recipient: "Acme", Location: "Westlands"}
+	if err := tx.Dispatches().Create(ctx, &ds, d.ID, v.ID); err != nil {
+		t.Fatal(err)
+	}
+	return d, v, ds
+}
+
+func TestTripTransition(t *testing.T) {
+	inRolledBackTx(t, func(t *testing.T, tx store.Repo) {
+		ctx := context.Background()
+		d, v, ds := seed(t, tx)
+
+		trip, err := tx.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trip.Status != store.TripAssigned || trip.Driver.IDNumber != d.IDNumber || trip.Vehicle.RegNo != v.RegNo {
+			t.Errorf("created trip = %+v", trip)
+		}
+
+		if trip, err = tx.Trips().Transition(ctx, trip.ID, store.TripAccepted, "driver:1", ""); err != nil || trip.Status != store.TripAccepted {
+			t.Fatalf("accept: %+v, %v", trip, err)
+		}
+
+		var te *store.TransitionError
+		_, err = tx.Trips().Transition(ctx, trip.ID, store.TripDelivered, "driver:1", "")
+		if !errors.As(err, &te) || te.From != store.TripAccepted || te.To != store.TripDelivered {
+			t.Errorf("accepted -> delivered: err = %v, want TransitionError from accepted", err)
+		}
+		if _, err := tx.Trips().Transition(ctx, trip.ID, "lost", "driver:1", ""); !errors.Is(err, store.ErrInvalidStatus) {
+			t.Errorf("unknown status: err = %v, want ErrInvalidStatus", err)
+		}
+		if _, err := tx.Trips().Transition(ctx, -1, store.TripAccepted, "driver:1", ""); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("missing trip: err = %v, want ErrNotFound", err)
+		}
+
+		if _, err := tx.Trips().Transition(ctx, trip.ID, store.TripEnRoute, "driver:1", ""); err != nil {
+			t.Fatal(err)
+		}
+		at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		if trip, err = tx.Trips().MarkArrived(ctx, trip.ID, at); err != nil || trip.ArrivedAt == nil || !trip.ArrivedAt.Equal(at) {
+			t.Fatalf("arrive: %+v, %v", trip, err)
+		}
+		if trip, err = tx.Trips().Transition(ctx, trip.ID, store.TripCancelled, "admin:1", "customer called"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Trips().UpdateLocation(ctx, trip.ID, store.Location{Latitude: 1, Longitude: 1}); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("moving a finished trip: err = %v, want ErrNotFound", err)
+		}
+
+		history, err := tx.Trips().History(ctx, trip.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{store.TripAccepted, store.TripEnRoute, store.TripArrived, store.TripCancelled}
+		if len(history) != len(want) {
+			t.Fatalf("history = %+v, want %d changes", history, len(want))
+		}
+		for i, c := range history {
+			if c.To != want[i] {
+				t.Errorf("change %d to %q, want %q", i, c.To, want[i])
+			}
+		}
+		last := history[len(history)-1]
+		if last.From == nil || *last.From != store.TripArrived || last.Actor != "admin:1" ||
+			last.Reason == nil || *last.Reason != "customer called" {
+			t.Errorf("last change = %+v", last)
+		}
+		if history[2].Actor != "system" {
+			t.Errorf("arrival actor = %q, want system", history[2].Actor)
+		}
+	})
+}
+
+func TestAssignmentsOpen(t *testing.T) {
+	inRolledBackTx(t, func(t *testing.T, tx store.Repo) {
+		ctx := context.Background()
+		d, v, ds := seed(t, tx)
+
+		first := store.Assignment{DispatchID: ds.ID, Driver: d, Vehicle: v, AssignedBy: "admin:1"}
+		if err := tx.Assignments().Open(ctx, &first); err != nil {
+			t.Fatal(err)
+		}
+		reason := "driver off sick"
+		second := store.Assignment{DispatchID: ds.ID, Driver: d, Vehicle: v, AssignedBy: "admin:2", Reason: &reason}
+		if err := tx.Assignments().Open(ctx, &second); err != nil {
+			t.Fatal(err)
+		}
+
+		list, err := tx.Assignments().ListByDispatch(ctx, ds.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+			t.Fatalf("assignments = %+v, want the two in order", list)
+		}
+		if list[0].EndedAt == nil {
+			t.Error("first assignment still open after the second was opened")
+		}
+		if list[1].EndedAt != nil || list[1].Reason == nil || *list[1].Reason != reason || list[1].AssignedBy != "admin:2" {
+			t.Errorf("current assignment = %+v", list[1])
+		}
+		if list[1].Driver.IDNumber != d.IDNumber || list[1].Vehicle.RegNo != v.RegNo {
+			t.Errorf("current holder = %+v / %+v, want the seeded driver and vehicle", list[1].Driver, list[1].Vehicle)
+		}
+
+		if empty, err := tx.Assignments().ListByDispatch(ctx, -1); err != nil || len(empty) != 0 {
+			t.Errorf("unknown dispatch: %v, %v; want an empty list", empty, err)
+		}
+	})
+}
+
+func TestTripsOpen(t *testing.T) {
+	inRolledBackTx(t, func(t *testing.T, tx store.Repo) {
+		ctx := context.Background()
+		d, v, ds := seed(t, tx)
+		open := func() []int {
+			t.Helper()
+			trips, err := tx.Trips().List(ctx, store.TripFilter{DispatchID: ds.ID, Open: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, trip := range trips {
+				ids = append(ids, trip.ID)
+			}
+			return ids
+		}
+
+		first, err := tx.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Trips().Transition(ctx, first.ID, store.TripCancelled, "driver:1", "sick"); err != nil {
+			t.Fatal(err)
+		}
+		if got := open(); !slices.Equal(got, []int{first.ID}) {
+			t.Errorf("pending dispatch after a cancelled trip: open trips = %v, want [%d]", got, first.ID)
+		}
+
+		second, err := tx.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := open(); !slices.Equal(got, []int{second.ID}) {
+			t.Errorf("after reassigning: open trips = %v, want [%d]", got, second.ID)
+		}
+
+		for _, next := range []string{store.TripAccepted, store.TripEnRoute, store.TripFailed} {
+			if _, err := tx.Trips().Transition(ctx, second.ID, next, "driver:1", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Dispatches().SetStatus(ctx, ds.ID, store.DispatchFailed); err != nil {
+			t.Fatal(err)
+		}
+		if got := open(); !slices.Equal(got, []int{second.ID}) {
+			t.Errorf("failed dispatch: open trips = %v, want [%d]", got, second.ID)
+		}
+
+		if err := tx.Dispatches().SetStatus(ctx, ds.ID, store.DispatchDelivered); err != nil {
+			t.Fatal(err)
+		}
+		if got := open(); len(got) != 0 {
+			t.Errorf("delivered dispatch: open trips = %v, want none", got)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package storetest
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+type deliveryStore struct{ s *Store }
+
+// byNewest orders deliveries by date, newest first, like the Postgres store
+func byNewest(a, b store.Delivery) int {
+	if c := b.Date.Compare(a.Date); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.ID, a.ID)
+}
+
+// listDeliveries returns the deliveries matching keep, newest first
+func (d *data) listDeliveries(keep func(store.Delivery) bool) []store.Delivery {
+	var list []store.Delivery
+	for _, del := range d.deliveries {
+		if keep(del) {
+			list = append(list, del)
+		}
+	}
+	slices.SortFunc(list, byNewest)
+	return list
+}
+
+// withFlags sets HasPhoto and HasSignature from the stored keys
+func withFlags(del store.Delivery) store.Delivery {
+	del.HasPhoto = del.PhotoKey != ""
+	del.HasSignature = del.SignatureKey != ""
+	return del
+}
+
+func (r *deliveryStore) Create(ctx context.Context, del *store.Delivery) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	del.ID = d.nextID("deliveries")
+	del.Date = now()
+	if del.Status == "" {
+		del.Status = store.DeliveryDelivered
+	}
+	*del = withFlags(*del)
+	d.deliveries[del.ID] = *del
+	return nil
+}
+
+func (r *deliveryStore) List(ctx context.Context) ([]store.Delivery, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDeliveries(func(store.Delivery) bool { return true }), nil
+}
+
+func (r *deliveryStore) ListByDispatch(ctx context.Context, dispatchID int) ([]store.Delivery, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDeliveries(func(del store.Delivery) bool { return del.DispatchID == dispatchID }), nil
+}
+
+func (r *deliveryStore) ListByDriver(ctx context.Context, driverID int) ([]store.Delivery, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDeliveries(func(del store.Delivery) bool {
+		t, ok := d.trips[del.TripID]
+		return ok && t.Driver.ID == driverID
+	}), nil
+}
+
+func (r *deliveryStore) Get(ctx context.Context, id int) (*store.Delivery, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	del, ok := d.deliveries[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &del, nil
+}
+
+func (r *deliveryStore) AttachPOD(ctx context.Context, id int, pod store.Delivery) (*store.Delivery, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	del, ok := d.deliveries[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	del.Recipient = cmp.Or(pod.Recipient, del.Recipient)
+	del.Condition = cmp.Or(pod.Condition, del.Condition)
+	del.Note = cmp.Or(pod.Note, del.Note)
+	del.PhotoKey = cmp.Or(pod.PhotoKey, del.PhotoKey)
+	del.SignatureKey = cmp.Or(pod.SignatureKey, del.SignatureKey)
+	del = withFlags(del)
+	d.deliveries[id] = del
+	return &del, nil
+}
+
+func (r *deliveryStore) Delete(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if _, ok := d.deliveries[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(d.deliveries, id)
+	return nil
+}
+
+type otpLogStore struct{ s *Store }
+
+// counted reports whether a is a successful or in-flight send
+func counted(a store.OTPAttempt) bool {
+	return a.Action == store.OTPSend && (a.Outcome == store.OTPSent || a.Outcome == store.OTPSending)
+}
+
+func (r *otpLogStore) Record(ctx context.Context, a *store.OTPAttempt) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	a.ID = d.nextID("dispatch_otp_log")
+	a.CreatedAt = now()
+	d.otpLog = append(d.otpLog, *a)
+	return nil
+}
+
+func (r *otpLogStore) Finish(ctx context.Context, id int, outcome string, detail *string) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for i := range d.otpLog {
+		if d.otpLog[i].ID == id && d.otpLog[i].Outcome == store.OTPSending {
+			d.otpLog[i].Outcome, d.otpLog[i].Detail = outcome, detail
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (r *otpLogStore) ListByDispatch(ctx context.Context, dispatchID int) ([]store.OTPAttempt, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	res := []store.OTPAttempt{}
+	for _, a := range d.otpLog {
+		if a.DispatchID == dispatchID {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+func (r *otpLogStore) LastSent(ctx context.Context, dispatchID int) (*store.OTPAttempt, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for _, a := range slices.Backward(d.otpLog) {
+		if a.DispatchID == dispatchID && counted(a) {
+			return &a, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (r *otpLogStore) CountSent(ctx context.Context, dispatchID int, since time.Time) (int, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	n := 0
+	for _, a := range d.otpLog {
+		if a.DispatchID == dispatchID && counted(a) && !a.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *otpLogStore) FailedVerifies(ctx context.Context, dispatchID int) (int, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	n := 0
+	for _, a := range d.otpLog {
+		switch {
+		case a.DispatchID != dispatchID:
+		case a.Action == store.OTPSend && a.Outcome == store.OTPSent:
+			n = 0
+		case a.Action == store.OTPVerify && a.Outcome == store.OTPRejected:
+			n++
+		}
+	}
+	return n, nil
+}
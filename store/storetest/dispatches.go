@@ -0,0 +1,388 @@
+package storetest
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+type driverStore struct{ s *Store }
+
+// plaintext matches the Postgres store's check for a legacy, unhashed password
+func plaintext(password string) bool {
+	return !(len(password) > 4 && strings.HasPrefix(password, "$2") && password[3] == '$')
+}
+
+func (r *driverStore) Create(ctx context.Context, dr *store.Driver, passwordHash string) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	dr.ID = d.nextID("drivers")
+	row := driverRow{Driver: *dr, hash: passwordHash}
+	row.Password = ""
+	d.drivers[dr.ID] = row
+	return nil
+}
+
+func (r *driverStore) List(ctx context.Context) ([]store.Driver, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var drivers []store.Driver
+	for _, id := range slices.Sorted(maps.Keys(d.drivers)) {
+		drivers = append(drivers, d.drivers[id].Driver)
+	}
+	return drivers, nil
+}
+
+func (r *driverStore) Get(ctx context.Context, id int) (*store.Driver, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	dr, ok := d.drivers[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &dr.Driver, nil
+}
+
+func (r *driverStore) IDByIDNumber(ctx context.Context, idNumber int) (int, error) {
+	id, _, err := r.Credentials(ctx, idNumber)
+	return id, err
+}
+
+func (r *driverStore) Credentials(ctx context.Context, idNumber int) (int, string, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for _, dr := range d.drivers {
+		if dr.IDNumber == idNumber {
+			return dr.ID, dr.hash, nil
+		}
+	}
+	return 0, "", store.ErrNotFound
+}
+
+func (r *driverStore) UpgradePassword(ctx context.Context, id int, passwordHash string) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if dr, ok := d.drivers[id]; ok && plaintext(dr.hash) {
+		dr.hash = passwordHash
+		d.drivers[id] = dr
+	}
+	return nil
+}
+
+func (r *driverStore) CountPlaintextPasswords(ctx context.Context) (int, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	n := 0
+	for _, dr := range d.drivers {
+		if plaintext(dr.hash) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *driverStore) UpdateLocation(ctx context.Context, id int, loc store.Location) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if dr, ok := d.drivers[id]; ok {
+		dr.location = &loc
+		d.drivers[id] = dr
+	}
+	return nil
+}
+
+func (r *driverStore) GetLocation(ctx context.Context, id int) (*store.Location, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	dr, ok := d.drivers[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	if dr.location == nil {
+		return &store.Location{}, nil
+	}
+	loc := *dr.location
+	return &loc, nil
+}
+
+type vehicleStore struct{ s *Store }
+
+func (r *vehicleStore) Create(ctx context.Context, v *store.Vehicle) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	v.ID = d.nextID("vehicles")
+	d.vehicles[v.ID] = *v
+	return nil
+}
+
+func (r *vehicleStore) List(ctx context.Context) ([]store.Vehicle, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var vehicles []store.Vehicle
+	for _, id := range slices.Sorted(maps.Keys(d.vehicles)) {
+		vehicles = append(vehicles, d.vehicles[id])
+	}
+	return vehicles, nil
+}
+
+func (r *vehicleStore) Get(ctx context.Context, id int) (*store.Vehicle, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	v, ok := d.vehicles[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &v, nil
+}
+
+func (r *vehicleStore) IDByRegNo(ctx context.Context, regNo string) (int, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	for _, v := range d.vehicles {
+		if v.RegNo == regNo {
+			return v.ID, nil
+		}
+	}
+	return 0, store.ErrNotFound
+}
+
+func (r *vehicleStore) Update(ctx context.Context, v *store.Vehicle) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if _, ok := d.vehicles[v.ID]; ok {
+		d.vehicles[v.ID] = *v
+	}
+	return nil
+}
+
+func (r *vehicleStore) Delete(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	delete(d.vehicles, id)
+	return nil
+}
+
+type dispatchStore struct{ s *Store }
+
+// dispatch fills in the driver and vehicle shown on the dashboard
+func (d *data) dispatch(ds store.Dispatch) store.Dispatch {
+	if dr, ok := d.drivers[ds.Driver.ID]; ok {
+		ds.Driver = store.Driver{ID: dr.ID, IDNumber: dr.IDNumber, FirstName: dr.FirstName + " " + dr.LastName}
+	}
+	if v, ok := d.vehicles[ds.Vehicle.ID]; ok {
+		ds.Vehicle = store.Vehicle{ID: v.ID, RegNo: v.RegNo}
+	}
+	return ds
+}
+
+// listDispatches returns the dispatches matching keep, newest first
+func (d *data) listDispatches(keep func(store.Dispatch) bool) []store.Dispatch {
+	var list []store.Dispatch
+	for _, ds := range d.dispatches {
+		if keep(ds) {
+			list = append(list, d.dispatch(ds))
+		}
+	}
+	slices.SortFunc(list, func(a, b store.Dispatch) int {
+		if c := b.Date.Compare(a.Date); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return list
+}
+
+func (r *dispatchStore) Create(ctx context.Context, ds *store.Dispatch, driverID, vehicleID int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	ds.ID = d.nextID("dispatches")
+	ds.Date = now()
+	ds.Verified = false
+	ds.Status = store.DispatchPending
+	row := *ds
+	row.Driver = store.Driver{ID: driverID}
+	row.Vehicle = store.Vehicle{ID: vehicleID}
+	d.dispatches[ds.ID] = row
+	return nil
+}
+
+func (r *dispatchStore) List(ctx context.Context) ([]store.Dispatch, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDispatches(func(store.Dispatch) bool { return true }), nil
+}
+
+func (r *dispatchStore) ListByStatus(ctx context.Context, status string) ([]store.Dispatch, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDispatches(func(ds store.Dispatch) bool { return ds.Status == status }), nil
+}
+
+func (r *dispatchStore) ListByDriver(ctx context.Context, driverID int) ([]store.Dispatch, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.listDispatches(func(ds store.Dispatch) bool { return ds.Driver.ID == driverID }), nil
+}
+
+func (r *dispatchStore) Get(ctx context.Context, id int) (*store.Dispatch, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	ds, ok := d.dispatches[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	ds = d.dispatch(ds)
+	return &ds, nil
+}
+
+func (r *dispatchStore) Update(ctx context.Context, ds *store.Dispatch) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	row, ok := d.dispatches[ds.ID]
+	if !ok {
+		return nil
+	}
+	row.Recipient, row.Phone, row.Location, row.Invoice = ds.Recipient, ds.Phone, ds.Location, ds.Invoice
+	row.Destination = ds.Destination
+	d.dispatches[ds.ID] = row
+	return nil
+}
+
+func (r *dispatchStore) Reassign(ctx context.Context, id, driverID, vehicleID int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	row, ok := d.dispatches[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	row.Driver = store.Driver{ID: driverID}
+	row.Vehicle = store.Vehicle{ID: vehicleID}
+	d.dispatches[id] = row
+	return nil
+}
+
+func (r *dispatchStore) Delete(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	delete(d.dispatches, id)
+	return nil
+}
+
+func (r *dispatchStore) Phone(ctx context.Context, id int) (string, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	ds, ok := d.dispatches[id]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return ds.Phone, nil
+}
+
+func (r *dispatchStore) MarkVerified(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if ds, ok := d.dispatches[id]; ok {
+		ds.Verified = true
+		ds.Status = store.DispatchDelivered
+		d.dispatches[id] = ds
+	}
+	return nil
+}
+
+func (r *dispatchStore) SetStatus(ctx context.Context, id int, status string) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	ds, ok := d.dispatches[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	ds.Status = status
+	d.dispatches[id] = ds
+	return nil
+}
+
+func (r *dispatchStore) Lock(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	if _, ok := d.dispatches[id]; !ok {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+type assignmentStore struct{ s *Store }
+
+func (r *assignmentStore) Open(ctx context.Context, a *store.Assignment) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	at := now()
+	for i := range d.assignments {
+		if d.assignments[i].DispatchID == a.DispatchID && d.assignments[i].EndedAt == nil {
+			d.assignments[i].EndedAt = &at
+		}
+	}
+	a.ID = d.nextID("dispatch_assignments")
+	a.AssignedAt = at
+	d.assignments = append(d.assignments, store.Assignment{
+		ID:         a.ID,
+		DispatchID: a.DispatchID,
+		Driver:     store.Driver{ID: a.Driver.ID},
+		Vehicle:    store.Vehicle{ID: a.Vehicle.ID},
+		TripID:     a.TripID,
+		AssignedBy: a.AssignedBy,
+		Reason:     a.Reason,
+		AssignedAt: at,
+	})
+	return nil
+}
+
+func (r *assignmentStore) ListByDispatch(ctx context.Context, dispatchID int) ([]store.Assignment, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	res := []store.Assignment{}
+	for _, a := range d.assignments {
+		if a.DispatchID != dispatchID {
+			continue
+		}
+		if dr, ok := d.drivers[a.Driver.ID]; ok {
+			a.Driver = store.Driver{ID: dr.ID, IDNumber: dr.IDNumber, FirstName: dr.FirstName, LastName: dr.LastName}
+		}
+		if v, ok := d.vehicles[a.Vehicle.ID]; ok {
+			a.Vehicle = store.Vehicle{ID: v.ID, RegNo: v.RegNo}
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
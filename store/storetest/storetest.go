@@ -0,0 +1,130 @@
+// Package storetest is an in-memory store.Repo for handler tests. It follows
+// the Postgres store's behaviour closely enough for request-level tests, but
+// has no row locks: Dispatches().Lock only checks the dispatch exists.
+package storetest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// adminRow and driverRow keep the password hash the public types leave out
+type adminRow struct {
+	store.AdminUser
+	hash string
+}
+
+type driverRow struct {
+	store.Driver
+	hash     string
+	location *store.Location
+}
+
+type tokenRow struct {
+	store.AdminToken
+	used bool
+}
+
+// data is every table. InTx snapshots it so a failed transaction rolls back.
+type data struct {
+	ids map[string]int // last id handed out per table
+
+	admins      map[int]adminRow
+	tokens      []tokenRow
+	drivers     map[int]driverRow
+	vehicles    map[int]store.Vehicle
+	dispatches  map[int]store.Dispatch
+	trips       map[int]store.Trip
+	history     []store.StatusChange
+	deliveries  map[int]store.Delivery
+	positions   []store.Position
+	assignments []store.Assignment
+	otpLog      []store.OTPAttempt
+}
+
+func (d *data) clone() *data {
+	return &data{
+		ids:         maps.Clone(d.ids),
+		admins:      maps.Clone(d.admins),
+		tokens:      slices.Clone(d.tokens),
+		drivers:     maps.Clone(d.drivers),
+		vehicles:    maps.Clone(d.vehicles),
+		dispatches:  maps.Clone(d.dispatches),
+		trips:       maps.Clone(d.trips),
+		history:     slices.Clone(d.history),
+		deliveries:  maps.Clone(d.deliveries),
+		positions:   slices.Clone(d.positions),
+		assignments: slices.Clone(d.assignments),
+		otpLog:      slices.Clone(d.otpLog),
+	}
+}
+
+// nextID returns the next serial id for table
+func (d *data) nextID(table string) int {
+	d.ids[table]++
+	return d.ids[table]
+}
+
+// Store is an in-memory store.Repo. The zero value is not usable; call New.
+type Store struct {
+	mu sync.Mutex
+	db *data
+}
+
+var _ store.Repo = (*Store)(nil)
+
+// New returns an empty store
+func New() *Store {
+	return &Store{db: &data{
+		ids:        map[string]int{},
+		admins:     map[int]adminRow{},
+		drivers:    map[int]driverRow{},
+		vehicles:   map[int]store.Vehicle{},
+		dispatches: map[int]store.Dispatch{},
+		trips:      map[int]store.Trip{},
+		deliveries: map[int]store.Delivery{},
+	}}
+}
+
+// lock holds the store for one repository call and returns the tables
+func (s *Store) lock() (*data, func()) {
+	s.mu.Lock()
+	return s.db, s.mu.Unlock
+}
+
+func (s *Store) Drivers() store.DriverStore         { return &driverStore{s} }
+func (s *Store) Vehicles() store.VehicleStore       { return &vehicleStore{s} }
+func (s *Store) Dispatches() store.DispatchStore    { return &dispatchStore{s} }
+func (s *Store) Trips() store.TripStore             { return &tripStore{s} }
+func (s *Store) Deliveries() store.DeliveryStore    { return &deliveryStore{s} }
+func (s *Store) Positions() store.PositionStore     { return &positionStore{s} }
+func (s *Store) Assignments() store.AssignmentStore { return &assignmentStore{s} }
+func (s *Store) OTPLog() store.OTPLogStore          { return &otpLogStore{s} }
+func (s *Store) AdminUsers() store.AdminUserStore   { return &adminUserStore{s} }
+func (s *Store) AdminTokens() store.AdminTokenStore { return &adminTokenStore{s} }
+
+// InTx runs fn against the same store and restores the tables if it fails.
+// Nested calls roll back only their own changes, like a savepoint.
+func (s *Store) InTx(ctx context.Context, fn func(tx store.Repo) error) error {
+	s.mu.Lock()
+	snapshot := s.db.clone()
+	s.mu.Unlock()
+
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		s.db = snapshot
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// now is the timestamp given to new rows
+func now() time.Time {
+	return time.Now().UTC()
+}
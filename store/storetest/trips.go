@@ -0,0 +1,271 @@
+package storetest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+type tripStore struct{ s *Store }
+
+// trip fills in the driver, vehicle and dispatch status a trip is read with
+func (d *data) trip(t store.Trip) *store.Trip {
+	t.Driver = store.Driver{ID: t.Driver.ID}
+	if dr, ok := d.drivers[t.Driver.ID]; ok {
+		t.Driver.IDNumber = dr.IDNumber
+	}
+	t.Vehicle = store.Vehicle{ID: t.Vehicle.ID}
+	if v, ok := d.vehicles[t.Vehicle.ID]; ok {
+		t.Vehicle.RegNo = v.RegNo
+	}
+	t.DispatchStatus = d.dispatches[t.DispatchID].Status
+	return &t
+}
+
+// lastTrip reports whether t is the newest trip of its dispatch
+func (d *data) lastTrip(t store.Trip) bool {
+	for _, other := range d.trips {
+		if other.DispatchID == t.DispatchID && other.ID > t.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// awaitingReschedule mirrors the SQL of the same name: t's dispatch failed or
+// was partly delivered, or is still pending after t was cancelled
+func (d *data) awaitingReschedule(t store.Trip) bool {
+	status := d.dispatches[t.DispatchID].Status
+	return store.NeedsReschedule(status) || (status == store.DispatchPending && t.Status == store.TripCancelled)
+}
+
+// activeFor returns the newest unfinished trip matching keep
+func (d *data) activeFor(keep func(store.Trip) bool) (*store.Trip, error) {
+	var found *store.Trip
+	for _, t := range d.trips {
+		if keep(t) && !store.TripIsFinal(t.Status) && (found == nil || t.ID > found.ID) {
+			found = d.trip(t)
+		}
+	}
+	if found == nil {
+		return nil, store.ErrNotFound
+	}
+	return found, nil
+}
+
+func (r *tripStore) Create(ctx context.Context, dispatchID, driverID, vehicleID int, destination, recipientName string) (*store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	t := store.Trip{
+		ID:            d.nextID("trips"),
+		DispatchID:    dispatchID,
+		Driver:        store.Driver{ID: driverID},
+		Vehicle:       store.Vehicle{ID: vehicleID},
+		Destination:   destination,
+		RecipientName: recipientName,
+		Status:        store.TripAssigned,
+		LastUpdated:   now(),
+	}
+	d.trips[t.ID] = t
+	return d.trip(t), nil
+}
+
+func (r *tripStore) List(ctx context.Context, f store.TripFilter) ([]store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var res []store.Trip
+	for _, id := range slices.Sorted(maps.Keys(d.trips)) {
+		t := d.trips[id]
+		active := !store.TripIsFinal(t.Status)
+		switch {
+		case f.DriverID != 0 && t.Driver.ID != f.DriverID,
+			f.DispatchID != 0 && t.DispatchID != f.DispatchID,
+			f.ActiveOnly && !active,
+			f.Open && !active && !(d.awaitingReschedule(t) && d.lastTrip(t)),
+			f.Status != "" && t.Status != f.Status:
+			continue
+		}
+		res = append(res, *d.trip(t))
+	}
+	return res, nil
+}
+
+func (r *tripStore) Get(ctx context.Context, id int) (*store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	t, ok := d.trips[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return d.trip(t), nil
+}
+
+func (r *tripStore) ActiveForDriver(ctx context.Context, driverID int) (*store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.activeFor(func(t store.Trip) bool { return t.Driver.ID == driverID })
+}
+
+func (r *tripStore) ActiveForDispatch(ctx context.Context, dispatchID int) (*store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	return d.activeFor(func(t store.Trip) bool { return t.DispatchID == dispatchID })
+}
+
+func (r *tripStore) Exists(ctx context.Context, id int) (bool, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	_, ok := d.trips[id]
+	return ok, nil
+}
+
+func (r *tripStore) UpdateLocation(ctx context.Context, id int, loc store.Location) (*store.Trip, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	t, ok := d.trips[id]
+	if !ok || store.TripIsFinal(t.Status) {
+		return nil, store.ErrNotFound
+	}
+	t.Latitude, t.Longitude, t.LastUpdated = loc.Latitude, loc.Longitude, now()
+	d.trips[id] = t
+	return d.trip(t), nil
+}
+
+func (r *tripStore) Transition(ctx context.Context, id int, status, actor, reason string) (*store.Trip, error) {
+	return r.transition(id, status, actor, reason, nil)
+}
+
+func (r *tripStore) MarkArrived(ctx context.Context, id int, at time.Time) (*store.Trip, error) {
+	return r.transition(id, store.TripArrived, "system", "", &at)
+}
+
+func (r *tripStore) transition(id int, status, actor, reason string, arrivedAt *time.Time) (*store.Trip, error) {
+	if !store.ValidTripStatus(status) {
+		return nil, store.ErrInvalidStatus
+	}
+
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	t, ok := d.trips[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	if !store.CanTransition(t.Status, status) {
+		return nil, &store.TransitionError{From: t.Status, To: status}
+	}
+
+	from := t.Status
+	change := store.StatusChange{TripID: id, From: &from, To: status, Actor: actor, CreatedAt: now()}
+	if reason != "" {
+		change.Reason = &reason
+	}
+	d.history = append(d.history, change)
+
+	t.Status, t.LastUpdated = status, now()
+	if status == store.TripArrived {
+		at := now()
+		if arrivedAt != nil {
+			at = *arrivedAt
+		}
+		t.ArrivedAt = &at
+	}
+	d.trips[id] = t
+	return d.trip(t), nil
+}
+
+func (r *tripStore) History(ctx context.Context, id int) ([]store.StatusChange, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	res := []store.StatusChange{}
+	for _, c := range d.history {
+		if c.TripID == id {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func (r *tripStore) Delete(ctx context.Context, id int) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	delete(d.trips, id)
+	return nil
+}
+
+type positionStore struct{ s *Store }
+
+// samePosition reports whether a and b are the same fix from the same trip or driver
+func samePosition(a, b store.Position) bool {
+	return a.TripID == b.TripID && a.DriverID == b.DriverID && a.RecordedAt.Equal(b.RecordedAt)
+}
+
+func (r *positionStore) Append(ctx context.Context, p *store.Position) error {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	d.positions = append(d.positions, *p)
+	return nil
+}
+
+func (r *positionStore) AppendBatch(ctx context.Context, ps []store.Position) (int64, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	batch := slices.Clone(ps)
+	slices.SortStableFunc(batch, func(a, b store.Position) int { return a.RecordedAt.Compare(b.RecordedAt) })
+
+	var added int64
+	for _, p := range batch {
+		if slices.ContainsFunc(d.positions, func(q store.Position) bool { return samePosition(p, q) }) {
+			continue
+		}
+		d.positions = append(d.positions, p)
+		added++
+	}
+	return added, nil
+}
+
+func (r *positionStore) Latest(ctx context.Context, tripID int) (*store.Position, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var latest *store.Position
+	for i, p := range d.positions {
+		if p.TripID == tripID && (latest == nil || !p.RecordedAt.Before(latest.RecordedAt)) {
+			latest = &d.positions[i]
+		}
+	}
+	if latest == nil {
+		return nil, store.ErrNotFound
+	}
+	p := *latest
+	return &p, nil
+}
+
+func (r *positionStore) ListByTrip(ctx context.Context, tripID int, from, to *time.Time) ([]store.Position, error) {
+	d, unlock := r.s.lock()
+	defer unlock()
+
+	var list []store.Position
+	for _, p := range d.positions {
+		if p.TripID != tripID || (from != nil && p.RecordedAt.Before(*from)) || (to != nil && p.RecordedAt.After(*to)) {
+			continue
+		}
+		list = append(list, p)
+	}
+	slices.SortStableFunc(list, func(a, b store.Position) int { return a.RecordedAt.Compare(b.RecordedAt) })
+	return list, nil
+}
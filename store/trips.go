@@ -0,0 +1,267 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Trip represents a delivery trip persisted in DB
+type Trip struct {
+	ID            int        `json:"id"`
+	DispatchID    int        `json:"dispatch_id"`
+	Dispatch      *Dispatch  `json:"dispatch,omitempty"`
+	Driver        Driver     `json:"driver"`
+	Vehicle       Vehicle    `json:"vehicle"`
+	Destination   string     `json:"destination"`
+	RecipientName string     `json:"recipient_name"`
+	Status        string     `json:"status"`
+	Latitude      float64    `json:"latitude"`
+	Longitude     float64    `json:"longitude"`
+	LastUpdated   time.Time  `json:"lastUpdated"`
+	ArrivedAt     *time.Time `json:"arrivedAt,omitempty"`
+	// DispatchStatus is the dispatch's outcome; failed or partial means it awaits rescheduling
+	DispatchStatus string `json:"dispatchStatus,omitempty"`
+}
+
+// TripFilter narrows TripStore.List. Zero values match everything.
+type TripFilter struct {
+	DriverID   int
+	DispatchID int
+	ActiveOnly bool   // exclude delivered, failed and cancelled trips
+	Open       bool   // active trips plus the last trip of each dispatch left without one (see awaitingReschedule)
+	Status     string // exact status
+}
+
+// TripStore persists trips
+type TripStore interface {
+	Create(ctx context.Context, dispatchID, driverID, vehicleID int, destination, recipientName string) (*Trip, error)
+	List(ctx context.Context, f TripFilter) ([]Trip, error)
+	Get(ctx context.Context, id int) (*Trip, error)
+	// ActiveForDriver returns the driver's most recent trip that isn't finished
+	ActiveForDriver(ctx context.Context, driverID int) (*Trip, error)
+	// ActiveForDispatch returns the dispatch's most recent trip that isn't finished
+	ActiveForDispatch(ctx context.Context, dispatchID int) (*Trip, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	// UpdateLocation moves a trip and returns it, or ErrNotFound if it is missing or finished
+	UpdateLocation(ctx context.Context, id int, loc Location) (*Trip, error)
+	// Transition moves a trip to status and records the change against actor,
+	// with an optional reason. It returns ErrInvalidStatus for an unknown status
+	// and a *TransitionError if the trip's current status can't move there.
+	Transition(ctx context.Context, id int, status, actor, reason string) (*Trip, error)
+	// MarkArrived transitions a trip to arrived as of at, on behalf of the system
+	MarkArrived(ctx context.Context, id int, at time.Time) (*Trip, error)
+	// History lists a trip's status changes, oldest first
+	History(ctx context.Context, id int) ([]StatusChange, error)
+	Delete(ctx context.Context, id int) error
+}
+
+type tripStore struct {
+	db DBTX
+}
+
+// tripSelect is shared by every trip read so the column list lives in one place
+const tripSelect = `
+	SELECT t.id, COALESCE(t.dispatch_id, 0), COALESCE(t.driver_id, 0), COALESCE(dr.id_number, 0),
+	       COALESCE(t.vehicle_id, 0), COALESCE(v.reg_no, ''),
+	       COALESCE(t.destination, ''), COALESCE(t.recipient_name, ''), COALESCE(t.status, ''),
+	       COALESCE(t.latitude, 0), COALESCE(t.longitude, 0), t.last_updated, t.arrived_at,
+	       COALESCE(ds.status, '')
+	FROM trips t
+	LEFT JOIN dispatches ds ON ds.id = t.dispatch_id
+	LEFT JOIN drivers dr ON dr.id = t.driver_id
+	LEFT JOIN vehicles v ON v.id = t.vehicle_id`
+
+func scanTrip(row interface{ Scan(...any) error }) (*Trip, error) {
+	var t Trip
+	err := row.Scan(&t.ID, &t.DispatchID, &t.Driver.ID, &t.Driver.IDNumber,
+		&t.Vehicle.ID, &t.Vehicle.RegNo,
+		&t.Destination, &t.RecipientName, &t.Status,
+		&t.Latitude, &t.Longitude, &t.LastUpdated, &t.ArrivedAt,
+		&t.DispatchStatus)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *tripStore) Create(ctx context.Context, dispatchID, driverID, vehicleID int, destination, recipientName string) (*Trip, error) {
+	var id int
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO trips (dispatch_id, driver_id, vehicle_id, destination, recipient_name, status, latitude, longitude, last_updated)
+		 VALUES ($1, $2, $3, $4, $5, 'assigned', 0, 0, NOW())
+		 RETURNING id`,
+		dispatchID, driverID, vehicleID, destination, recipientName,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *tripStore) List(ctx context.Context, f TripFilter) ([]Trip, error) {
+	var where []string
+	var args []any
+	if f.DriverID != 0 {
+		args = append(args, f.DriverID)
+		where = append(where, "t.driver_id=$"+strconv.Itoa(len(args)))
+	}
+	if f.DispatchID != 0 {
+		args = append(args, f.DispatchID)
+		where = append(where, "t.dispatch_id=$"+strconv.Itoa(len(args)))
+	}
+	if f.ActiveOnly {
+		where = append(where, "t."+activeTrip)
+	}
+	if f.Open {
+		where = append(where, "(t."+activeTrip+" OR "+awaitingReschedule+")")
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, "t.status=$"+strconv.Itoa(len(args)))
+	}
+
+	q := tripSelect
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY t.id"
+
+	rows, err := s.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Trip
+	for rows.Next() {
+		t, err := scanTrip(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *t)
+	}
+	return res, rows.Err()
+}
+
+func (s *tripStore) Get(ctx context.Context, id int) (*Trip, error) {
+	t, err := scanTrip(s.db.QueryRow(ctx, tripSelect+" WHERE t.id=$1", id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return t, nil
+}
+
+func (s *tripStore) ActiveForDriver(ctx context.Context, driverID int) (*Trip, error) {
+	t, err := scanTrip(s.db.QueryRow(ctx,
+		tripSelect+" WHERE t.driver_id=$1 AND t."+activeTrip+" ORDER BY t.id DESC LIMIT 1", driverID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return t, nil
+}
+
+func (s *tripStore) ActiveForDispatch(ctx context.Context, dispatchID int) (*Trip, error) {
+	t, err := scanTrip(s.db.QueryRow(ctx,
+		tripSelect+" WHERE t.dispatch_id=$1 AND t."+activeTrip+" ORDER BY t.id DESC LIMIT 1", dispatchID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return t, nil
+}
+
+func (s *tripStore) Exists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM trips WHERE id=$1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (s *tripStore) UpdateLocation(ctx context.Context, id int, loc Location) (*Trip, error) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE trips SET latitude=$1, longitude=$2, last_updated=NOW()
+		 WHERE id=$3 AND `+activeTrip,
+		loc.Latitude, loc.Longitude, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := scanTrip(s.db.QueryRow(ctx, tripSelect+" WHERE t.id=$1 AND t."+activeTrip, id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return t, nil
+}
+
+func (s *tripStore) Transition(ctx context.Context, id int, status, actor, reason string) (*Trip, error) {
+	return s.transition(ctx, id, status, actor, reason, nil)
+}
+
+func (s *tripStore) MarkArrived(ctx context.Context, id int, at time.Time) (*Trip, error) {
+	return s.transition(ctx, id, TripArrived, "system", "", &at)
+}
+
+// transition checks, applies and records a status change in one statement so
+// it stays atomic outside a transaction too. arrivedAt defaults to now.
+func (s *tripStore) transition(ctx context.Context, id int, status, actor, reason string, arrivedAt *time.Time) (*Trip, error) {
+	if !ValidTripStatus(status) {
+		return nil, ErrInvalidStatus
+	}
+
+	var changed int
+	err := s.db.QueryRow(ctx,
+		`WITH cur AS (
+		     SELECT id, status FROM trips WHERE id=$1 FOR UPDATE
+		 ), upd AS (
+		     UPDATE trips t SET status=$2::text, last_updated=NOW(),
+		            arrived_at = CASE WHEN $2::text = 'arrived' THEN COALESCE($4::timestamp, NOW()) ELSE t.arrived_at END
+		     FROM cur
+		     WHERE t.id=cur.id AND cur.status = ANY($3::text[])
+		     RETURNING t.id, cur.status AS from_status
+		 )
+		 INSERT INTO trip_status_history (trip_id, from_status, to_status, actor, reason)
+		 SELECT id, from_status, $2::text, $5, NULLIF($6, '') FROM upd
+		 RETURNING trip_id`,
+		id, status, allowedFrom(status), arrivedAt, actor, reason,
+	).Scan(&changed)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	t, getErr := s.Get(ctx, id)
+	if getErr != nil {
+		return nil, getErr
+	}
+	if err != nil {
+		return nil, &TransitionError{From: t.Status, To: status}
+	}
+	return t, nil
+}
+
+func (s *tripStore) History(ctx context.Context, id int) ([]StatusChange, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT trip_id, from_status, to_status, actor, reason, created_at
+		 FROM trip_status_history WHERE trip_id=$1 ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.TripID, &c.From, &c.To, &c.Actor, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+func (s *tripStore) Delete(ctx context.Context, id int) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM trips WHERE id=$1`, id)
+	return err
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Trip statuses, in lifecycle order
+const (
+	TripAssigned  = "assigned"
+	TripAccepted  = "accepted"
+	TripEnRoute   = "en_route"
+	TripArrived   = "arrived"
+	TripDelivered = "delivered"
+	TripFailed    = "failed"
+	TripCancelled = "cancelled"
+)
+
+// tripTransitions lists the statuses each status may move to. Delivery can be
+// confirmed en route when the OTP is verified before the geofence fires.
+var tripTransitions = map[string][]string{
+	TripAssigned:  {TripAccepted, TripCancelled},
+	TripAccepted:  {TripEnRoute, TripCancelled},
+	TripEnRoute:   {TripArrived, TripDelivered, TripFailed, TripCancelled},
+	TripArrived:   {TripDelivered, TripFailed, TripCancelled},
+	TripDelivered: {},
+	TripFailed:    {},
+	TripCancelled: {},
+}
+
+// activeTrip matches trips that haven't reached a final status
+const activeTrip = "status NOT IN ('delivered', 'failed', 'cancelled')"
+
+// ErrInvalidStatus is returned for a status outside the trip lifecycle
+var ErrInvalidStatus = errors.New("unknown trip status")
+
+// TransitionError is returned when a trip can't move from its current status to the requested one
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("trip cannot move from %s to %s", e.From, e.To)
+}
+
+// ValidTripStatus reports whether status is part of the trip lifecycle
+func ValidTripStatus(status string) bool {
+	_, ok := tripTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether a trip may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, s := range tripTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TripIsFinal reports whether a trip has reached delivered, failed or cancelled
+func TripIsFinal(status string) bool {
+	return ValidTripStatus(status) && len(tripTransitions[status]) == 0
+}
+
+// allowedFrom returns the statuses that may move to status
+func allowedFrom(status string) []string {
+	var from []string
+	for s, next := range tripTransitions {
+		for _, n := range next {
+			if n == status {
+				from = append(from, s)
+			}
+		}
+	}
+	return from
+}
+
+// StatusChange is a trip_status_history row
+type StatusChange struct {
+	TripID    int       `json:"tripId"`
+	From      *string   `json:"from"`
+	To        string    `json:"to"`
+	Actor     string    `json:"actor"`
+	Reason    *string   `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
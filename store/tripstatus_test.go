@@ -0,0 +1,81 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+var allTripStatuses = []string{
+	TripAssigned, TripAccepted, TripEnRoute, TripArrived,
+	TripDelivered, TripFailed, TripCancelled,
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   []string // every status from may move to
+	}{
+		{from: TripAssigned, to: []string{TripAccepted, TripCancelled}},
+		{from: TripAccepted, to: []string{TripEnRoute, TripCancelled}},
+		{from: TripEnRoute, to: []string{TripArrived, TripDelivered, TripFailed, TripCancelled}},
+		{from: TripArrived, to: []string{TripDelivered, TripFailed, TripCancelled}},
+		{from: TripDelivered},
+		{from: TripFailed},
+		{from: TripCancelled},
+		{from: "unknown"},
+	}
+
+	for _, tt := range tests {
+		for _, to := range append(allTripStatuses, "unknown") {
+			want := slices.Contains(tt.to, to)
+			if got := CanTransition(tt.from, to); got != want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestTripIsFinal(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{TripAssigned, false},
+		{TripAccepted, false},
+		{TripEnRoute, false},
+		{TripArrived, false},
+		{TripDelivered, true},
+		{TripFailed, true},
+		{TripCancelled, true},
+		{"unknown", false},
+	}
+
+	for _, tt := range tests {
+		if got := TripIsFinal(tt.status); got != tt.want {
+			t.Errorf("TripIsFinal(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedFrom(t *testing.T) {
+	tests := []struct {
+		status string
+		want   []string
+	}{
+		{TripAssigned, nil},
+		{TripAccepted, []string{TripAssigned}},
+		{TripEnRoute, []string{TripAccepted}},
+		{TripArrived, []string{TripEnRoute}},
+		{TripDelivered, []string{TripArrived, TripEnRoute}},
+		{TripFailed, []string{TripArrived, TripEnRoute}},
+		{TripCancelled, []string{TripAccepted, TripArrived, TripAssigned, TripEnRoute}},
+	}
+
+	for _, tt := range tests {
+		got := allowedFrom(tt.status)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("allowedFrom(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
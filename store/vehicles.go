@@ -0,0 +1,80 @@
+package store
+
+import "context"
+
+// Vehicle struct
+type Vehicle struct {
+	ID     int    `json:"id"`
+	Type   string `json:"type"`
+	RegNo  string `json:"reg_no"`
+	Status bool   `json:"status"`
+}
+
+// VehicleStore persists vehicles
+type VehicleStore interface {
+	Create(ctx context.Context, v *Vehicle) error
+	List(ctx context.Context) ([]Vehicle, error)
+	Get(ctx context.Context, id int) (*Vehicle, error)
+	// IDByRegNo resolves a registration number to vehicles.id
+	IDByRegNo(ctx context.Context, regNo string) (int, error)
+	Update(ctx context.Context, v *Vehicle) error
+	Delete(ctx context.Context, id int) error
+}
+
+type vehicleStore struct {
+	db DBTX
+}
+
+func (s *vehicleStore) Create(ctx context.Context, v *Vehicle) error {
+	return s.db.QueryRow(ctx,
+		`INSERT INTO vehicles (type, reg_no, status) VALUES ($1, $2, $3) RETURNING id`,
+		v.Type, v.RegNo, v.Status,
+	).Scan(&v.ID)
+}
+
+func (s *vehicleStore) List(ctx context.Context) ([]Vehicle, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, type, reg_no, status FROM vehicles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vehicles []Vehicle
+	for rows.Next() {
+		var v Vehicle
+		if err := rows.Scan(&v.ID, &v.Type, &v.RegNo, &v.Status); err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, v)
+	}
+	return vehicles, rows.Err()
+}
+
+func (s *vehicleStore) Get(ctx context.Context, id int) (*Vehicle, error) {
+	var v Vehicle
+	err := s.db.QueryRow(ctx,
+		`SELECT id, type, reg_no, status FROM vehicles WHERE id = $1`, id,
+	).Scan(&v.ID, &v.Type, &v.RegNo, &v.Status)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &v, nil
+}
+
+func (s *vehicleStore) IDByRegNo(ctx context.Context, regNo string) (int, error) {
+	var id int
+	err := s.db.QueryRow(ctx, `SELECT id FROM vehicles WHERE reg_no=$1`, regNo).Scan(&id)
+	return id, notFound(err)
+}
+
+func (s *vehicleStore) Update(ctx context.Context, v *Vehicle) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE vehicles SET type=$1, reg_no=$2, status=$3 WHERE id=$4`,
+		v.Type, v.RegNo, v.Status, v.ID)
+	return err
+}
+
+func (s *vehicleStore) Delete(ctx context.Context, id int) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM vehicles WHERE id=$1`, id)
+	return err
+}
@@ -0,0 +1,108 @@
+package tracking
+
+import (
+	"math"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+const earthRadius = 6371000.0 // metres
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Distance returns the great-circle distance between two points in metres
+func Distance(a, b store.Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// offsetFrom projects p onto a flat plane in metres around origin.
+// Good enough over the length of a single segment.
+func offsetFrom(origin, p store.Location) (x, y float64) {
+	x = radians(p.Longitude-origin.Longitude) * math.Cos(radians(origin.Latitude)) * earthRadius
+	y = radians(p.Latitude-origin.Latitude) * earthRadius
+	return x, y
+}
+
+// segmentDistance returns how far p is from the segment a-b in metres
+func segmentDistance(p, a, b store.Location) float64 {
+	bx, by := offsetFrom(a, b)
+	px, py := offsetFrom(a, p)
+
+	lenSq := bx*bx + by*by
+	if lenSq == 0 {
+		return math.Hypot(px, py)
+	}
+
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lenSq))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// Simplify drops points that lie within tolerance metres of the simplified
+// path (Douglas-Peucker). The first and last points are always kept.
+func Simplify(points []store.Position, tolerance float64) []store.Position {
+	if tolerance <= 0 || len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// Iterative to avoid deep recursion on very long trips
+	type span struct{ first, last int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		a, b := points[s.first].Location(), points[s.last].Location()
+		maxDist, index := 0.0, -1
+		for i := s.first + 1; i < s.last; i++ {
+			if d := segmentDistance(points[i].Location(), a, b); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+
+		if index != -1 && maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, span{s.first, index}, span{index, s.last})
+		}
+	}
+
+	out := make([]store.Position, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// LineString is a GeoJSON geometry
+type LineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"` // [longitude, latitude]
+}
+
+// Feature is a GeoJSON feature
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   LineString             `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// ToLineString converts ordered fixes to a GeoJSON LineString
+func ToLineString(points []store.Position) LineString {
+	coords := make([][2]float64, len(points))
+	for i, p := range points {
+		coords[i] = [2]float64{p.Longitude, p.Latitude}
+	}
+	return LineString{Type: "LineString", Coordinates: coords}
+}
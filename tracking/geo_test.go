@@ -0,0 +1,96 @@
+package tracking
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// path builds positions from latitude/longitude pairs
+func path(coords ...[2]float64) []store.Position {
+	ps := make([]store.Position, len(coords))
+	for i, c := range coords {
+		ps[i] = store.Position{Latitude: c[0], Longitude: c[1]}
+	}
+	return ps
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b store.Location
+		want float64 // metres
+	}{
+		{"same point", store.Location{Latitude: -1.29, Longitude: 36.82}, store.Location{Latitude: -1.29, Longitude: 36.82}, 0},
+		{"one degree of latitude", store.Location{Latitude: 0, Longitude: 0}, store.Location{Latitude: 1, Longitude: 0}, 111195},
+		{"one degree of longitude at the equator", store.Location{Latitude: 0, Longitude: 36}, store.Location{Latitude: 0, Longitude: 37}, 111195},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); math.Abs(got-tt.want) > 1 {
+			t.Errorf("%s: Distance = %.0f, want %.0f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	// 0.001 degrees is about 111 m
+	tests := []struct {
+		name      string
+		points    []store.Position
+		tolerance float64
+		want      []store.Position
+	}{
+		{
+			name:      "too short to simplify",
+			points:    path([2]float64{0, 0}, [2]float64{0, 0.001}),
+			tolerance: 10,
+			want:      path([2]float64{0, 0}, [2]float64{0, 0.001}),
+		},
+		{
+			name:      "zero tolerance keeps everything",
+			points:    path([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0, 0.002}),
+			tolerance: 0,
+			want:      path([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0, 0.002}),
+		},
+		{
+			name:      "straight line keeps the ends",
+			points:    path([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0, 0.002}, [2]float64{0, 0.003}),
+			tolerance: 1,
+			want:      path([2]float64{0, 0}, [2]float64{0, 0.003}),
+		},
+		{
+			name:      "wobble within tolerance is dropped",
+			points:    path([2]float64{0, 0}, [2]float64{0.00005, 0.001}, [2]float64{0, 0.002}),
+			tolerance: 10,
+			want:      path([2]float64{0, 0}, [2]float64{0, 0.002}),
+		},
+		{
+			name:      "corner beyond tolerance is kept",
+			points:    path([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0.001, 0.001}),
+			tolerance: 10,
+			want:      path([2]float64{0, 0}, [2]float64{0, 0.001}, [2]float64{0.001, 0.001}),
+		},
+		{
+			name: "only the peak of a detour is kept",
+			points: path([2]float64{0, 0}, [2]float64{0.001, 0.001}, [2]float64{0.002, 0.002},
+				[2]float64{0.001, 0.003}, [2]float64{0, 0.004}),
+			tolerance: 20,
+			want:      path([2]float64{0, 0}, [2]float64{0.002, 0.002}, [2]float64{0, 0.004}),
+		},
+	}
+
+	for _, tt := range tests {
+		got := Simplify(tt.points, tt.tolerance)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Simplify kept %d points, want %d: %v", tt.name, len(got), len(tt.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i].Location() != tt.want[i].Location() {
+				t.Errorf("%s: point %d = %v, want %v", tt.name, i, got[i].Location(), tt.want[i].Location())
+			}
+		}
+	}
+}
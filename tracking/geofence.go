@@ -0,0 +1,84 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// DefaultGeofenceRadius applies to dispatch destinations saved without a radius
+var DefaultGeofenceRadius = 150.0 // metres
+
+var arrivalHooks []func(ctx context.Context, t *store.Trip)
+
+// OnArrival registers fn to run after a trip enters its destination geofence,
+// e.g. to text the recipient their OTP. Hooks run once, on the instance that
+// detected the arrival, in the background.
+func OnArrival(fn func(ctx context.Context, t *store.Trip)) {
+	arrivalHooks = append(arrivalHooks, fn)
+}
+
+// detectArrival marks the trip arrived at the first of the ordered fixes that
+// falls inside its dispatch's geofence. It returns the updated trip, or nil
+// if the trip didn't arrive.
+func detectArrival(ctx context.Context, tx store.Repo, trip *store.Trip, fixes []store.Position) (*store.Trip, error) {
+	// Only trips under way can arrive; assigned or accepted trips haven't left yet
+	if trip.DispatchID == 0 || !store.CanTransition(trip.Status, store.TripArrived) {
+		return nil, nil
+	}
+
+	d, err := tx.Dispatches().Get(ctx, trip.DispatchID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Destination == nil {
+		return nil, nil
+	}
+
+	radius := d.Destination.Radius
+	if radius <= 0 {
+		radius = DefaultGeofenceRadius
+	}
+
+	for _, p := range fixes {
+		if Distance(p.Location(), d.Destination.Center()) > radius {
+			continue
+		}
+		arrived, err := tx.Trips().MarkArrived(ctx, trip.ID, p.RecordedAt)
+		var te *store.TransitionError
+		if errors.As(err, &te) {
+			return nil, nil // moved on concurrently
+		}
+		return arrived, err
+	}
+	return nil, nil
+}
+
+// announceArrival emits trip_arrived and runs the arrival hooks
+func announceArrival(t *store.Trip) {
+	events.Publish("trip_arrived", map[string]interface{}{
+		"trip":      t,
+		"arrivedAt": t.ArrivedAt,
+	})
+
+	for _, fn := range arrivalHooks {
+		go func(fn func(context.Context, *store.Trip)) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[Tracking] arrival hook for trip %d panicked: %v\n", t.ID, r)
+				}
+			}()
+			fn(ctx, t)
+		}(fn)
+	}
+}
@@ -0,0 +1,283 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mangochops/coninx_backend/events"
+	"github.com/mangochops/coninx_backend/store"
+)
+
+// ErrNotOwner is returned when a driver reports a position for someone else's trip
+var ErrNotOwner = errors.New("trip belongs to another driver")
+
+// ErrInvalidFix is returned for a fix with coordinates out of range, or a
+// batch fix without a device time
+var ErrInvalidFix = errors.New("invalid fix")
+
+// onGlobe reports whether p's coordinates are in range
+func onGlobe(p store.Position) bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+// MaxBatchFixes bounds one offline-buffer upload
+var MaxBatchFixes = 1000
+
+var repo store.Repo
+
+// Init sets the store used to persist positions
+func Init(s store.Repo) {
+	repo = s
+}
+
+// UpdateTripLocation moves a trip, appends the fix to its breadcrumb history
+// and announces it as a location_update event, which reaches the admin SSE
+// stream and the driver WebSocket hub.
+// driverID is the reporting driver's drivers.id, or 0 when an admin reports.
+// It returns store.ErrNotFound if the trip is missing or finished.
+func UpdateTripLocation(ctx context.Context, tripID, driverID int, p store.Position) (*store.Trip, error) {
+	if repo == nil {
+		return nil, errors.New("tracking store not initialized")
+	}
+	if !onGlobe(p) {
+		return nil, ErrInvalidFix
+	}
+	// Device times are stored without a zone, so they must all be UTC
+	if p.RecordedAt.IsZero() {
+		p.RecordedAt = time.Now()
+	}
+	p.RecordedAt = p.RecordedAt.UTC()
+
+	var t, arrived *store.Trip
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		current, err := tx.Trips().Get(ctx, tripID)
+		if err != nil {
+			return err
+		}
+		if driverID != 0 && current.Driver.ID != driverID {
+			return ErrNotOwner
+		}
+
+		t, err = tx.Trips().UpdateLocation(ctx, tripID, p.Location())
+		if err != nil {
+			return err
+		}
+
+		p.TripID = tripID
+		p.DriverID = current.Driver.ID
+		if err := tx.Positions().Append(ctx, &p); err != nil {
+			return err
+		}
+
+		arrived, err = detectArrival(ctx, tx, t, []store.Position{p})
+		if arrived != nil {
+			t = arrived
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish("location_update", map[string]interface{}{
+		"trip": t,
+	})
+	if arrived != nil {
+		announceArrival(arrived)
+	}
+	return t, nil
+}
+
+// UpdateDriverLocation stores a driver's own position. If the driver has an
+// active trip the fix also moves the trip, is announced as a location_update
+// and is checked against the destination geofence, as UpdateTripLocation does.
+func UpdateDriverLocation(ctx context.Context, driverID int, p store.Position) error {
+	if repo == nil {
+		return errors.New("tracking store not initialized")
+	}
+	if !onGlobe(p) {
+		return ErrInvalidFix
+	}
+	if p.RecordedAt.IsZero() {
+		p.RecordedAt = time.Now()
+	}
+	p.RecordedAt = p.RecordedAt.UTC()
+
+	var t, arrived *store.Trip
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		if err := tx.Drivers().UpdateLocation(ctx, driverID, p.Location()); err != nil {
+			return err
+		}
+
+		p.DriverID = driverID
+		p.TripID = 0
+		active, err := tx.Trips().ActiveForDriver(ctx, driverID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return tx.Positions().Append(ctx, &p)
+		case err != nil:
+			return err
+		}
+
+		p.TripID = active.ID
+		t, err = tx.Trips().UpdateLocation(ctx, active.ID, p.Location())
+		if errors.Is(err, store.ErrNotFound) {
+			// Finished since we looked; keep the fix as the driver's own
+			t, p.TripID = nil, 0
+			return tx.Positions().Append(ctx, &p)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Positions().Append(ctx, &p); err != nil {
+			return err
+		}
+
+		arrived, err = detectArrival(ctx, tx, t, []store.Position{p})
+		if arrived != nil {
+			t = arrived
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if t != nil {
+		events.Publish("location_update", map[string]interface{}{
+			"trip": t,
+		})
+	}
+	if arrived != nil {
+		announceArrival(arrived)
+	}
+	return nil
+}
+
+// BatchResult summarises an uploaded batch of fixes
+type BatchResult struct {
+	Received int         `json:"received"`
+	Stored   int64       `json:"stored"` // new fixes; duplicates of stored ones are skipped
+	TripID   int         `json:"tripId,omitempty"`
+	Trip     *store.Trip `json:"trip,omitempty"` // set when the newest fix moved the trip
+}
+
+// RecordBatch stores fixes a driver buffered while offline. Fixes are ordered
+// and de-duplicated by device time; only the newest one updates the current
+// position of the trip and driver, and only if nothing newer is already known.
+// tripID 0 records against the driver's active trip, if any.
+func RecordBatch(ctx context.Context, driverID, tripID int, fixes []store.Position) (*BatchResult, error) {
+	if repo == nil {
+		return nil, errors.New("tracking store not initialized")
+	}
+
+	for i, p := range fixes {
+		if p.RecordedAt.IsZero() || !onGlobe(p) {
+			return nil, fmt.Errorf("%w at index %d", ErrInvalidFix, i)
+		}
+	}
+
+	sorted := make([]store.Position, len(fixes))
+	for i, p := range fixes {
+		p.RecordedAt = p.RecordedAt.UTC()
+		sorted[i] = p
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].RecordedAt.Before(sorted[j].RecordedAt) })
+
+	unique := sorted[:0]
+	for _, p := range sorted {
+		if len(unique) > 0 && p.RecordedAt.Equal(unique[len(unique)-1].RecordedAt) {
+			continue
+		}
+		unique = append(unique, p)
+	}
+
+	res := &BatchResult{Received: len(fixes)}
+	var arrived *store.Trip
+	if len(unique) == 0 {
+		return res, nil
+	}
+	newest := unique[len(unique)-1]
+
+	err := repo.InTx(ctx, func(tx store.Repo) error {
+		var trip *store.Trip
+		var err error
+		if tripID != 0 {
+			trip, err = tx.Trips().Get(ctx, tripID)
+			if err != nil {
+				return err
+			}
+			if trip.Driver.ID != driverID {
+				return ErrNotOwner
+			}
+		} else {
+			trip, err = tx.Trips().ActiveForDriver(ctx, driverID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+
+		// Something newer may already have arrived live; don't move backwards
+		moveTrip := false
+		if trip != nil {
+			res.TripID = trip.ID
+			latest, err := tx.Positions().Latest(ctx, trip.ID)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				moveTrip = true
+			case err != nil:
+				return err
+			default:
+				moveTrip = newest.RecordedAt.After(latest.RecordedAt)
+			}
+		}
+
+		for i := range unique {
+			unique[i].DriverID = driverID
+			unique[i].TripID = res.TripID
+		}
+		res.Stored, err = tx.Positions().AppendBatch(ctx, unique)
+		if err != nil {
+			return err
+		}
+
+		if trip == nil {
+			return tx.Drivers().UpdateLocation(ctx, driverID, newest.Location())
+		}
+
+		// Buffered fixes may show the driver reached the destination while offline
+		arrived, err = detectArrival(ctx, tx, trip, unique)
+		if err != nil {
+			return err
+		}
+
+		if !moveTrip {
+			return nil
+		}
+		if err := tx.Drivers().UpdateLocation(ctx, driverID, newest.Location()); err != nil {
+			return err
+		}
+		res.Trip, err = tx.Trips().UpdateLocation(ctx, trip.ID, newest.Location())
+		if errors.Is(err, store.ErrNotFound) {
+			// Finished since; keep the history but leave the final position alone
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Trip != nil {
+		events.Publish("location_update", map[string]interface{}{
+			"trip": res.Trip,
+		})
+	}
+	if arrived != nil {
+		announceArrival(arrived)
+	}
+	return res, nil
+}
@@ -0,0 +1,112 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mangochops/coninx_backend/store"
+	"github.com/mangochops/coninx_backend/store/storetest"
+)
+
+// seedTrip creates a driver and a trip for them under way
+func seedTrip(t *testing.T, s *storetest.Store) *store.Trip {
+	t.Helper()
+	ctx := context.Background()
+
+	d := store.Driver{FirstName: "Jane", LastName: "Doe", IDNumber: 12345678}
+	if err := s.Drivers().Create(ctx, &d, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	v := store.Vehicle{Type: "van", RegNo: "KAA 123A"}
+	if err := s.Vehicles().Create(ctx, &v); err != nil {
+		t.Fatal(err)
+	}
+	ds := store.Dispatch{Recipient: "Acme", Location: "Westlands"}
+	if err := s.Dispatches().Create(ctx, &ds, d.ID, v.ID); err != nil {
+		t.Fatal(err)
+	}
+	trip, err := s.Trips().Create(ctx, ds.ID, d.ID, v.ID, ds.Location, ds.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, next := range []string{store.TripAccepted, store.TripEnRoute} {
+		if trip, err = s.Trips().Transition(ctx, trip.ID, next, "test", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return trip
+}
+
+func TestUpdateTripLocation(t *testing.T) {
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	at := time.Date(2026, 1, 2, 9, 0, 0, 0, nairobi)
+
+	tests := []struct {
+		name    string
+		fix     store.Position
+		wantErr error
+	}{
+		{"local device time", store.Position{Latitude: -1.29, Longitude: 36.82, RecordedAt: at}, nil},
+		{"latitude out of range", store.Position{Latitude: 91, Longitude: 36.82, RecordedAt: at}, ErrInvalidFix},
+		{"longitude out of range", store.Position{Latitude: -1.29, Longitude: -181, RecordedAt: at}, ErrInvalidFix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := storetest.New()
+			Init(s)
+			trip := seedTrip(t, s)
+
+			_, err := UpdateTripLocation(ctx, trip.ID, 0, tt.fix)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			track, err := s.Positions().ListByTrip(ctx, trip.ID, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != nil {
+				if len(track) != 0 {
+					t.Errorf("stored %d fixes, want none", len(track))
+				}
+				return
+			}
+			if len(track) != 1 || track[0].RecordedAt.Location() != time.UTC || !track[0].RecordedAt.Equal(at) {
+				t.Errorf("stored %v, want one fix at %v in UTC", track, at.UTC())
+			}
+		})
+	}
+}
+
+func TestRecordBatchStoresUTC(t *testing.T) {
+	ctx := context.Background()
+	s := storetest.New()
+	Init(s)
+	trip := seedTrip(t, s)
+
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	fixes := []store.Position{
+		{Latitude: -1.29, Longitude: 36.82, RecordedAt: time.Date(2026, 1, 2, 9, 0, 0, 0, nairobi)},
+		{Latitude: -1.30, Longitude: 36.83, RecordedAt: time.Date(2026, 1, 2, 6, 1, 0, 0, time.UTC)},
+	}
+	if _, err := RecordBatch(ctx, trip.Driver.ID, trip.ID, fixes); err != nil {
+		t.Fatal(err)
+	}
+
+	track, err := s.Positions().ListByTrip(ctx, trip.ID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(track) != 2 {
+		t.Fatalf("stored %d fixes, want 2", len(track))
+	}
+	for i, p := range track {
+		if p.RecordedAt.Location() != time.UTC || !p.RecordedAt.Equal(fixes[i].RecordedAt) {
+			t.Errorf("fix %d at %v, want %v in UTC", i, p.RecordedAt, fixes[i].RecordedAt.UTC())
+		}
+	}
+}